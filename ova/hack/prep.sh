@@ -154,6 +154,7 @@ scp_to /var/lib/sk8/ \
   "${script_dir}/../../sk8.sh" \
   "${script_dir}/../sk8.service" \
   "${script_dir}/../sk8-config-keys.env" \
+  "${script_dir}/../ovf/product-section.ovf" \
   "${script_dir}/../"*.sh \
   "${script_dir}/../../hack/new-ca.sh" \
   "${script_dir}/../../hack/new-cert.sh" \
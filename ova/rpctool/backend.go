@@ -0,0 +1,161 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const guestinfoPrefix = "guestinfo."
+
+// Backend is the store that holds the guestinfo keys. The method set
+// matches rpcvmx.Config so the VMX backdoor may be used directly.
+type Backend interface {
+	// String returns the value of the guestinfo key or defaultValue
+	// if the key is not set.
+	String(key, defaultValue string) (string, error)
+
+	// SetString sets the value of the guestinfo key.
+	SetString(key, value string) error
+}
+
+// batcher is implemented by backends that can service several requests
+// with a single session.
+type batcher interface {
+	Batch(fn func() error) error
+}
+
+// batch runs fn in a single session if config supports it.
+func batch(config Backend, fn func() error) error {
+	if b, ok := config.(batcher); ok {
+		return b.Batch(fn)
+	}
+	return fn()
+}
+
+// hostLogger is implemented by backends that can write to the VM's log
+// file on the host.
+type hostLogger interface {
+	HostLog(msg string) error
+}
+
+// hostLog writes the message to the VM's log file on the host if config
+// supports it, otherwise the message is written to stderr.
+func hostLog(config Backend, msg string) error {
+	if vb, ok := config.(*valueBackend); ok {
+		config = vb.Backend
+	}
+	if l, ok := config.(hostLogger); ok {
+		return l.HostLog(msg)
+	}
+	_, err := fmt.Fprintf(os.Stderr, "host log: %s\n", msg)
+	return err
+}
+
+// newBackend returns the Backend described by spec. Valid specs are:
+//
+//	vmx          the VMX backdoor; requires running inside a VM
+//	memory       an empty, in-memory store
+//	memory:PATH  an in-memory store seeded from the file at PATH
+//	file:PATH    a store backed by the file at PATH
+//
+// Files with a ".json" extension are treated as a JSON object of
+// string values. All other files are treated as KEY=VAL lines.
+func newBackend(spec string) (Backend, error) {
+	kind, path := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		kind, path = spec[:i], spec[i+1:]
+	}
+	switch strings.ToLower(kind) {
+	case "vmx":
+		if path != "" {
+			return nil, fmt.Errorf("invalid backend: %s", spec)
+		}
+		b, err := newVmxBackend()
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "memory":
+		b := newMemoryBackend()
+		if path != "" {
+			data, err := readBackendFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range data {
+				b.data[k] = v
+			}
+		}
+		return b, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("invalid backend: %s: path required", spec)
+		}
+		return newFileBackend(path)
+	}
+	return nil, fmt.Errorf("invalid backend: %s", spec)
+}
+
+// guestinfoKey returns the key with the "guestinfo." prefix, adding
+// the prefix if it is missing, just like rpcvmx.Config.
+func guestinfoKey(key string) string {
+	if strings.HasPrefix(key, guestinfoPrefix) {
+		return key
+	}
+	return guestinfoPrefix + key
+}
+
+// memoryBackend is a Backend that stores the guestinfo keys in memory.
+type memoryBackend struct {
+	sync.RWMutex
+	data map[string]string
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{data: map[string]string{}}
+}
+
+func (b *memoryBackend) String(key, defaultValue string) (string, error) {
+	b.RLock()
+	defer b.RUnlock()
+	if val, ok := b.data[guestinfoKey(key)]; ok {
+		return val, nil
+	}
+	return defaultValue, nil
+}
+
+func (b *memoryBackend) SetString(key, value string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.data[guestinfoKey(key)] = value
+	return nil
+}
+
+// keys returns the sorted names of the stored keys.
+func (b *memoryBackend) keys() []string {
+	b.RLock()
+	defer b.RUnlock()
+	keys := make([]string, 0, len(b.data))
+	for k := range b.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,245 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileBackend is a Backend that persists the guestinfo keys to a file
+// after every write. It is useful for exercising rpctool and the sk8
+// scripts outside of a virtual machine.
+//
+// A session holds an exclusive lock on PATH.lock, so the read-modify-write
+// of a session, ex. "cas", is not interleaved with the writes of other
+// processes.
+type fileBackend struct {
+	*memoryBackend
+	path string
+
+	// depth is the number of nested sessions. The lock is acquired by the
+	// outermost session. Sessions must not be run concurrently.
+	depth int
+}
+
+func newFileBackend(path string) (*fileBackend, error) {
+	b := &fileBackend{memoryBackend: newMemoryBackend(), path: path}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Batch locks and reloads the backing file so that a session, such as
+// each poll of the watch command, sees the changes made by other
+// processes, and other processes do not see the session's changes until
+// it ends.
+func (b *fileBackend) Batch(fn func() error) error {
+	if b.depth == 0 {
+		unlock, err := lockFile(b.path + ".lock")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := b.load(); err != nil {
+			return err
+		}
+	}
+	b.depth++
+	defer func() { b.depth-- }()
+	return fn()
+}
+
+// load replaces the stored keys with the contents of the backing file.
+func (b *fileBackend) load() error {
+	data, err := readBackendFile(b.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if data == nil {
+		data = map[string]string{}
+	}
+	b.Lock()
+	b.data = data
+	b.Unlock()
+	return nil
+}
+
+// SetString sets the key in a session so that the keys written by other
+// processes since the file was loaded are not overwritten.
+func (b *fileBackend) SetString(key, value string) error {
+	return b.Batch(func() error {
+		if err := b.memoryBackend.SetString(key, value); err != nil {
+			return err
+		}
+		return b.save()
+	})
+}
+
+// save atomically writes the stored keys to the backing file.
+func (b *fileBackend) save() error {
+	var buf bytes.Buffer
+	if isJSONFile(b.path) {
+		b.RLock()
+		out, err := json.MarshalIndent(b.data, "", "  ")
+		b.RUnlock()
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", b.path, err)
+		}
+		buf.Write(out)
+		buf.WriteByte('\n')
+	} else {
+		for _, k := range b.keys() {
+			val, _ := b.memoryBackend.String(k, "")
+			fmt.Fprintf(&buf, "%s=%s\n", k, quoteEnvFileValue(val))
+		}
+	}
+	return writeFileAtomic(b.path, buf.Bytes(), 0644)
+}
+
+// readBackendFile reads the guestinfo keys from the file at path. Every
+// key is normalized to include the "guestinfo." prefix.
+func readBackendFile(path string) (map[string]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{}
+	if isJSONFile(path) {
+		var raw map[string]string
+		if err := json.Unmarshal(buf, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+		}
+		for k, v := range raw {
+			data[guestinfoKey(k)] = v
+		}
+		return data, nil
+	}
+	keys, vals, err := parseEnvDoc(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		data[guestinfoKey(k)] = vals[k]
+	}
+	return data, nil
+}
+
+// parseEnvDoc parses KEY=VAL lines. Blank lines and lines that begin
+// with "#" are ignored. A VAL that begins with a double quote is
+// unquoted with strconv.Unquote. The keys are returned in the order in
+// which they first appear.
+func parseEnvDoc(name string, buf []byte) ([]string, map[string]string, error) {
+	var keys []string
+	vals := map[string]string{}
+	for n, line := range strings.Split(string(buf), "\n") {
+		key, val, err := parseEnvLine(name, n+1, line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if key == "" {
+			continue
+		}
+		if _, ok := vals[key]; !ok {
+			keys = append(keys, key)
+		}
+		vals[key] = val
+	}
+	return keys, vals, nil
+}
+
+// parseEnvLine parses line n of an env document as described by
+// parseEnvDoc. The key is empty if the line is blank or a comment.
+func parseEnvLine(name string, n int, line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+	i := strings.IndexByte(line, '=')
+	if i < 1 {
+		return "", "", fmt.Errorf("invalid line in %s:%d: %s", name, n, line)
+	}
+	key, val := strings.TrimSpace(line[:i]), line[i+1:]
+	if strings.HasPrefix(val, `"`) {
+		var err error
+		if val, err = strconv.Unquote(val); err != nil {
+			return "", "", fmt.Errorf(
+				"invalid value in %s:%d: %v", name, n, err)
+		}
+	}
+	return key, val, nil
+}
+
+// quoteEnvFileValue returns val as-is unless it must be quoted in order
+// to be read back by parseEnvDoc.
+func quoteEnvFileValue(val string) string {
+	if strings.HasPrefix(val, `"`) ||
+		strings.ContainsAny(val, "\r\n") ||
+		strings.TrimSpace(val) != val {
+		return strconv.Quote(val)
+	}
+	return val
+}
+
+func isJSONFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// as path and then renames the temporary file to path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicOwner(path, data, perm, -1, -1)
+}
+
+// writeFileAtomicOwner is like writeFileAtomic but the temporary file is
+// owned by uid and gid before it is renamed, so the file never appears at
+// path with the wrong owner. A uid or gid of -1 is not changed.
+func writeFileAtomicOwner(
+	path string, data []byte, perm os.FileMode, uid, gid int) error {
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := f.Chown(uid, gid); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to chown %s: %v", path, err)
+		}
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
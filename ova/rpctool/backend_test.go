@@ -0,0 +1,234 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempDir returns a new temporary directory and a function that removes
+// it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "rpctool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestNewBackend(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	seed := filepath.Join(dir, "seed.env")
+	if err := ioutil.WriteFile(seed, []byte("sk8.A=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "memory"},
+		{spec: "MEMORY"},
+		{spec: "memory:" + seed},
+		{spec: "memory:" + filepath.Join(dir, "missing.env"), wantErr: true},
+		{spec: "file:" + filepath.Join(dir, "new.json")},
+		{spec: "file:", wantErr: true},
+		{spec: "vmx:path", wantErr: true},
+		{spec: "bogus", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			_, err := newBackend(tc.spec)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("newBackend(%q) error = %v, wantErr %v",
+					tc.spec, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testCases := []struct {
+		name    string
+		setKey  string
+		getKey  string
+		value   string
+		def     string
+		wantVal string
+	}{
+		{
+			name:    "with prefix",
+			setKey:  "guestinfo.sk8.A",
+			getKey:  "guestinfo.sk8.A",
+			value:   "1",
+			wantVal: "1",
+		},
+		{
+			name:    "without prefix",
+			setKey:  "sk8.A",
+			getKey:  "guestinfo.sk8.A",
+			value:   "1",
+			wantVal: "1",
+		},
+		{
+			name:    "empty value",
+			setKey:  "sk8.A",
+			getKey:  "sk8.A",
+			def:     "default",
+			wantVal: "",
+		},
+		{
+			name:    "unset key",
+			setKey:  "sk8.A",
+			getKey:  "sk8.B",
+			value:   "1",
+			def:     "default",
+			wantVal: "default",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newMemoryBackend()
+			if err := b.SetString(tc.setKey, tc.value); err != nil {
+				t.Fatal(err)
+			}
+			val, err := b.String(tc.getKey, tc.def)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val != tc.wantVal {
+				t.Errorf("String(%q) = %q, want %q", tc.getKey, val, tc.wantVal)
+			}
+		})
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	values := map[string]string{
+		"sk8.PLAIN":     "value",
+		"sk8.EMPTY":     "",
+		"sk8.MULTILINE": "line 1\nline 2\n",
+		"sk8.SPACES":    "  padded  ",
+		"sk8.QUOTED":    `"quoted"`,
+		"sk8.EQUALS":    "a=b",
+	}
+	for _, name := range []string{"guestinfo.json", "guestinfo.env"} {
+		t.Run(name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			path := filepath.Join(dir, name)
+
+			b, err := newFileBackend(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range values {
+				if err := b.SetString(k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The values are read back from the file by a new backend.
+			b, err = newFileBackend(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range values {
+				val, err := b.String(k, "unset")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if val != want {
+					t.Errorf("String(%q) = %q, want %q", k, val, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileBackendBatch(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "guestinfo.json")
+
+	b1, err := newFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := newFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A write does not discard the keys written by another backend
+	// since the file was loaded.
+	if err := b1.SetString("sk8.A", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b2.SetString("sk8.B", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b1.Batch(func() error {
+		for k, want := range map[string]string{"sk8.A": "1", "sk8.B": "2"} {
+			val, err := b1.String(k, "")
+			if err != nil {
+				return err
+			}
+			if val != want {
+				t.Errorf("String(%q) = %q, want %q", k, val, want)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseEnvLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		wantKey string
+		wantVal string
+		wantErr bool
+	}{
+		{line: ""},
+		{line: "   "},
+		{line: "# comment"},
+		{line: "A=1", wantKey: "A", wantVal: "1"},
+		{line: " A = 1", wantKey: "A", wantVal: " 1"},
+		{line: "A=", wantKey: "A"},
+		{line: "A=a=b", wantKey: "A", wantVal: "a=b"},
+		{line: `A="a\nb"`, wantKey: "A", wantVal: "a\nb"},
+		{line: `A="unterminated`, wantErr: true},
+		{line: "=1", wantErr: true},
+		{line: "A", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.line, func(t *testing.T) {
+			key, val, err := parseEnvLine("test", 1, tc.line)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if key != tc.wantKey || val != tc.wantVal {
+				t.Errorf("parseEnvLine(%q) = %q, %q, want %q, %q",
+					tc.line, key, val, tc.wantKey, tc.wantVal)
+			}
+		})
+	}
+}
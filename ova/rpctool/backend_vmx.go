@@ -0,0 +1,100 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmware/vmw-guestinfo/rpcout"
+	"github.com/vmware/vmw-guestinfo/vmcheck"
+)
+
+// vmxBackend is a Backend that reads and writes the guestinfo keys via
+// the VMX backdoor. Unlike rpcvmx.Config, a vmxBackend may send several
+// requests over a single RPC channel. Please see Batch.
+type vmxBackend struct {
+	out *rpcout.RPCOut
+}
+
+// newVmxBackend returns a vmxBackend. An error is returned if the program
+// is not running inside a virtual machine.
+func newVmxBackend() (*vmxBackend, error) {
+	isVM, err := vmcheck.IsVirtualWorld()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover virtual world: %v", err)
+	}
+	if !isVM {
+		return nil, errors.New("must be run inside a virtual machine")
+	}
+	return &vmxBackend{}, nil
+}
+
+func (b *vmxBackend) String(key, defaultValue string) (string, error) {
+	out, ok, err := b.send("info-get %s", guestinfoKey(key))
+	if err != nil {
+		return "", err
+	} else if !ok {
+		return defaultValue, nil
+	}
+	return string(out), nil
+}
+
+func (b *vmxBackend) SetString(key, value string) error {
+	_, _, err := b.send("info-set %s %s", guestinfoKey(key), value)
+	return err
+}
+
+// Batch opens a single RPC channel that is used for every request sent
+// by fn. The channel is closed when fn returns.
+func (b *vmxBackend) Batch(fn func() error) error {
+	if b.out != nil {
+		return fn()
+	}
+	out := &rpcout.RPCOut{}
+	if err := out.Start(); err != nil {
+		return fmt.Errorf("failed to open rpc channel: %v", err)
+	}
+	b.out = out
+	err := fn()
+	b.out = nil
+	if stopErr := out.Stop(); stopErr != nil && err == nil {
+		err = fmt.Errorf("failed to close rpc channel: %v", stopErr)
+	}
+	return err
+}
+
+// HostLog writes the message to the VM's log file on the host,
+// vmware.log, with the "log" RPC.
+func (b *vmxBackend) HostLog(msg string) error {
+	_, ok, err := b.send("log %s", msg)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errors.New("the host rejected the log request")
+	}
+	return nil
+}
+
+// send sends the request over the open RPC channel or a throw-away
+// channel if no channel is open.
+func (b *vmxBackend) send(
+	format string, a ...interface{}) ([]byte, bool, error) {
+
+	if b.out == nil {
+		return rpcout.SendOne(format, a...)
+	}
+	return b.out.Send([]byte(fmt.Sprintf(format, a...)))
+}
@@ -0,0 +1,403 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// ovfEnvGuestinfoKey is the guestinfo key of the OVF environment.
+const ovfEnvGuestinfoKey = "guestinfo.ovfEnv"
+
+// vsphereBackend is a Backend that reads and writes the guestinfo keys of
+// another VM via the vSphere API. The guestinfo keys are the VM's
+// extraConfig, except for guestinfo.ovfEnv, which is the OVF environment
+// built from the VM's vApp properties. Setting guestinfo.ovfEnv updates
+// the vApp properties, which the VM receives when it is next powered on.
+type vsphereBackend struct {
+	ctx    context.Context
+	client *vim25.Client
+	vm     types.ManagedObjectReference
+
+	// config is the VM's configuration while a batch is open.
+	config *types.VirtualMachineConfigInfo
+}
+
+// newVsphereBackend logs into the vCenter or ESXi host in the environment
+// variable GOVC_URL and returns a backend for the VM with the BIOS UUID
+// uuid or the inventory path ipath. Like govc, the credentials may be
+// set in the URL or with GOVC_USERNAME and GOVC_PASSWORD, and
+// GOVC_INSECURE skips the verification of the server's certificate.
+func newVsphereBackend(uuid, ipath string) (*vsphereBackend, error) {
+	if (uuid == "") == (ipath == "") {
+		return nil, errors.New("one of vm.uuid or vm.ipath is required")
+	}
+	u, err := soap.ParseURL(os.Getenv("GOVC_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GOVC_URL: %v", err)
+	}
+	if u == nil {
+		return nil, errors.New("GOVC_URL is not set")
+	}
+	user, pass := u.User.Username(), ""
+	if p, ok := u.User.Password(); ok {
+		pass = p
+	}
+	if v := os.Getenv("GOVC_USERNAME"); v != "" {
+		user = v
+	}
+	if v := os.Getenv("GOVC_PASSWORD"); v != "" {
+		pass = v
+	}
+	u.User = url.UserPassword(user, pass)
+	insecure, _ := strconv.ParseBool(os.Getenv("GOVC_INSECURE"))
+
+	ctx := context.Background()
+	client, err := vim25.NewClient(ctx, soap.NewClient(u, insecure))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", u.Host, err)
+	}
+	if _, err := methods.Login(ctx, client, &types.Login{
+		This:     *client.ServiceContent.SessionManager,
+		UserName: user,
+		Password: pass,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to log into %s: %v", u.Host, err)
+	}
+	b := &vsphereBackend{ctx: ctx, client: client}
+
+	var ref *types.ManagedObjectReference
+	if uuid != "" {
+		res, err := methods.FindByUuid(ctx, client, &types.FindByUuid{
+			This:     *client.ServiceContent.SearchIndex,
+			Uuid:     uuid,
+			VmSearch: true,
+		})
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to find VM %s: %v", uuid, err)
+		}
+		ref = res.Returnval
+	} else {
+		res, err := methods.FindByInventoryPath(
+			ctx, client, &types.FindByInventoryPath{
+				This:          *client.ServiceContent.SearchIndex,
+				InventoryPath: ipath,
+			})
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to find VM %s: %v", ipath, err)
+		}
+		ref = res.Returnval
+	}
+	if ref == nil || ref.Type != "VirtualMachine" {
+		b.Close()
+		return nil, fmt.Errorf("VM not found: %s%s", uuid, ipath)
+	}
+	b.vm = *ref
+	return b, nil
+}
+
+// Close logs out of the session opened by newVsphereBackend, so a program
+// run once per key does not leave a session behind for each run.
+func (b *vsphereBackend) Close() error {
+	_, err := methods.Logout(b.ctx, b.client, &types.Logout{
+		This: *b.client.ServiceContent.SessionManager,
+	})
+	return err
+}
+
+func (b *vsphereBackend) String(key, defaultValue string) (string, error) {
+	config, err := b.vmConfig()
+	if err != nil {
+		return "", err
+	}
+	key = guestinfoKey(key)
+	if key == ovfEnvGuestinfoKey {
+		return b.ovfEnv(config), nil
+	}
+	for _, o := range config.ExtraConfig {
+		if ov := o.GetOptionValue(); ov.Key == key {
+			return fmt.Sprint(ov.Value), nil
+		}
+	}
+	return defaultValue, nil
+}
+
+func (b *vsphereBackend) SetString(key, value string) error {
+	config, err := b.vmConfig()
+	if err != nil {
+		return err
+	}
+	key = guestinfoKey(key)
+	var spec types.VirtualMachineConfigSpec
+	if key == ovfEnvGuestinfoKey {
+		vspec, err := vappConfigSpec(config, value)
+		if err != nil {
+			return err
+		}
+		if vspec == nil {
+			return nil
+		}
+		spec.VAppConfig = vspec
+	} else {
+		// An empty value removes the key from the extraConfig.
+		spec.ExtraConfig = []types.BaseOptionValue{
+			&types.OptionValue{Key: key, Value: value},
+		}
+	}
+	if err := b.reconfigure(spec); err != nil {
+		return fmt.Errorf("failed to reconfigure %s: %v", b.vm.Value, err)
+	}
+	// The configuration is read again the next time it is needed.
+	if b.config != nil {
+		b.config, err = b.retrieveConfig()
+	}
+	return err
+}
+
+// Batch reads the VM's configuration once for every request sent by fn.
+func (b *vsphereBackend) Batch(fn func() error) error {
+	if b.config != nil {
+		return fn()
+	}
+	config, err := b.retrieveConfig()
+	if err != nil {
+		return err
+	}
+	b.config = config
+	err = fn()
+	b.config = nil
+	return err
+}
+
+// vmConfig returns the configuration read by the open batch or reads it.
+func (b *vsphereBackend) vmConfig() (
+	*types.VirtualMachineConfigInfo, error) {
+
+	if b.config != nil {
+		return b.config, nil
+	}
+	return b.retrieveConfig()
+}
+
+// retrieveConfig reads the VM's extraConfig and vApp configuration.
+func (b *vsphereBackend) retrieveConfig() (
+	*types.VirtualMachineConfigInfo, error) {
+
+	var vm mo.VirtualMachine
+	if err := mo.RetrievePropertiesForRequest(
+		b.ctx, b.client, types.RetrieveProperties{
+			This: b.client.ServiceContent.PropertyCollector,
+			SpecSet: []types.PropertyFilterSpec{{
+				ObjectSet: []types.ObjectSpec{{Obj: b.vm}},
+				PropSet: []types.PropertySpec{{
+					Type: b.vm.Type,
+					PathSet: []string{
+						"config.extraConfig", "config.vAppConfig"},
+				}},
+			}},
+		}, &vm); err != nil {
+		return nil, fmt.Errorf(
+			"failed to get the configuration of %s: %v", b.vm.Value, err)
+	}
+	if vm.Config == nil {
+		return &types.VirtualMachineConfigInfo{}, nil
+	}
+	return vm.Config, nil
+}
+
+// reconfigureTimeout is how long reconfigure waits for the task to
+// complete.
+const reconfigureTimeout = 5 * time.Minute
+
+// reconfigure applies the spec to the VM and waits for the task to
+// complete, or until reconfigureTimeout elapses.
+func (b *vsphereBackend) reconfigure(
+	spec types.VirtualMachineConfigSpec) error {
+
+	ctx, cancel := context.WithTimeout(b.ctx, reconfigureTimeout)
+	defer cancel()
+	res, err := methods.ReconfigVM_Task(
+		ctx, b.client, &types.ReconfigVM_Task{This: b.vm, Spec: spec})
+	if err != nil {
+		return err
+	}
+	for {
+		var task mo.Task
+		if err := mo.RetrieveProperties(
+			ctx, b.client, b.client.ServiceContent.PropertyCollector,
+			res.Returnval, &task); err != nil {
+			return err
+		}
+		switch task.Info.State {
+		case types.TaskInfoStateSuccess:
+			return nil
+		case types.TaskInfoStateError:
+			if e := task.Info.Error; e != nil {
+				return errors.New(e.LocalizedMessage)
+			}
+			return errors.New("task failed")
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"timed out waiting for task %s: %v", res.Returnval.Value, ctx.Err())
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// ovfEnv returns the OVF environment built from the VM's vApp properties.
+// A property without a value has its default value.
+func (b *vsphereBackend) ovfEnv(
+	config *types.VirtualMachineConfigInfo) string {
+
+	vapp, ok := config.VAppConfig.(*types.VmConfigInfo)
+	if !ok || vapp == nil {
+		return ""
+	}
+	env := &ovf.Env{
+		EsxID:    b.vm.Value,
+		Property: &ovf.PropertySection{},
+	}
+	for _, p := range vapp.Property {
+		val := p.Value
+		if val == "" {
+			val = p.DefaultValue
+		}
+		env.Property.Properties = append(env.Property.Properties,
+			ovf.EnvProperty{Key: vappPropertyKey(p), Value: val})
+	}
+	return marshalOvfEnv(env)
+}
+
+// vappPropertyKey returns the key of the vApp property in the OVF
+// environment, qualified by its class and instance, if any.
+func vappPropertyKey(p types.VAppPropertyInfo) string {
+	key := p.Id
+	if p.ClassId != "" {
+		key = p.ClassId + "." + key
+	}
+	if p.InstanceId != "" {
+		key = key + "." + p.InstanceId
+	}
+	return key
+}
+
+// vappConfigSpec returns the changes to the VM's vApp properties that
+// make its OVF environment match the document, or nil if there are none.
+// Properties that are not in the document are removed, and properties
+// that are not declared by the VM are added as user-configurable string
+// properties. A property is compared with its value, not its default
+// value, so a value in the document is set even if it is the default.
+func vappConfigSpec(
+	config *types.VirtualMachineConfigInfo,
+	doc string) (*types.VmConfigSpec, error) {
+
+	var env ovf.Env
+	if strings.TrimSpace(doc) != "" {
+		if err := xml.Unmarshal([]byte(doc), &env); err != nil {
+			return nil, fmt.Errorf(
+				"failed to unmarshall OVF environment: %v", err)
+		}
+	}
+	vals := map[string]string{}
+	var keys []string
+	if env.Property != nil {
+		for _, p := range env.Property.Properties {
+			if _, ok := vals[strings.ToUpper(p.Key)]; !ok {
+				keys = append(keys, p.Key)
+			}
+			vals[strings.ToUpper(p.Key)] = p.Value
+		}
+	}
+
+	var props []types.VAppPropertyInfo
+	vapp, _ := config.VAppConfig.(*types.VmConfigInfo)
+	if vapp != nil {
+		props = vapp.Property
+	}
+	spec := &types.VmConfigSpec{}
+	declared := map[string]bool{}
+	var maxKey int32
+	for _, p := range props {
+		if p.Key > maxKey {
+			maxKey = p.Key
+		}
+		key := strings.ToUpper(vappPropertyKey(p))
+		declared[key] = true
+		val, ok := vals[key]
+		if !ok {
+			spec.Property = append(spec.Property, types.VAppPropertySpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{
+					Operation: types.ArrayUpdateOperationRemove,
+					RemoveKey: p.Key,
+				},
+			})
+			continue
+		}
+		if val != p.Value {
+			spec.Property = append(spec.Property, types.VAppPropertySpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{
+					Operation: types.ArrayUpdateOperationEdit,
+				},
+				Info: &types.VAppPropertyInfo{Key: p.Key, Value: val},
+			})
+		}
+	}
+	for _, k := range keys {
+		if declared[strings.ToUpper(k)] {
+			continue
+		}
+		maxKey++
+		spec.Property = append(spec.Property, types.VAppPropertySpec{
+			ArrayUpdateSpec: types.ArrayUpdateSpec{
+				Operation: types.ArrayUpdateOperationAdd,
+			},
+			Info: &types.VAppPropertyInfo{
+				Key:              maxKey,
+				Id:               k,
+				Type:             "string",
+				UserConfigurable: types.NewBool(true),
+				Value:            vals[strings.ToUpper(k)],
+			},
+		})
+	}
+	if len(spec.Property) == 0 {
+		return nil, nil
+	}
+
+	// The guest only receives the OVF environment with a transport.
+	if vapp == nil || len(vapp.OvfEnvironmentTransport) == 0 {
+		spec.OvfEnvironmentTransport = []string{"com.vmware.guestInfo"}
+	}
+	return spec, nil
+}
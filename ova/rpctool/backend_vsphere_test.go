@@ -0,0 +1,250 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// newTestVcsim starts a simulated vCenter and points GOVC_URL at it. It
+// returns the simulator's first VM and a function that stops the
+// simulator.
+func newTestVcsim(t *testing.T) (*simulator.VirtualMachine, func()) {
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+	s := model.Service.NewServer()
+
+	env := map[string]string{
+		"GOVC_URL":      s.URL.String(),
+		"GOVC_USERNAME": "",
+		"GOVC_PASSWORD": "",
+		"GOVC_INSECURE": "true",
+	}
+	prev := map[string]string{}
+	for k, v := range env {
+		prev[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+
+	vm := simulator.Map.Any("VirtualMachine").(*simulator.VirtualMachine)
+	return vm, func() {
+		for k, v := range prev {
+			os.Setenv(k, v)
+		}
+		s.Close()
+		model.Remove()
+	}
+}
+
+func TestNewVsphereBackend(t *testing.T) {
+	vm, cleanup := newTestVcsim(t)
+	defer cleanup()
+
+	testCases := []struct {
+		name    string
+		uuid    string
+		ipath   string
+		wantErr bool
+	}{
+		{name: "uuid", uuid: vm.Config.Uuid},
+		{name: "ipath", ipath: "/DC0/vm/" + vm.Name},
+		{name: "unknown uuid", uuid: "00000000-0000-0000-0000-000000000000",
+			wantErr: true},
+		{name: "unknown ipath", ipath: "/DC0/vm/missing", wantErr: true},
+		{name: "not a VM", ipath: "/DC0/host", wantErr: true},
+		{name: "uuid and ipath", uuid: vm.Config.Uuid,
+			ipath: "/DC0/vm/" + vm.Name, wantErr: true},
+		{name: "neither", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := newVsphereBackend(tc.uuid, tc.ipath)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer b.Close()
+			if b.vm != vm.Reference() {
+				t.Errorf("vm = %v, want %v", b.vm, vm.Reference())
+			}
+		})
+	}
+}
+
+func TestVsphereBackend(t *testing.T) {
+	vm, cleanup := newTestVcsim(t)
+	defer cleanup()
+
+	b, err := newVsphereBackend(vm.Config.Uuid, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// The simulator appends the extraConfig of a reconfiguration, so each
+	// key is set once.
+	testCases := []struct {
+		name    string
+		key     string
+		set     bool
+		value   string
+		def     string
+		wantVal string
+	}{
+		{
+			name:    "set with prefix",
+			key:     "guestinfo.sk8.A",
+			set:     true,
+			value:   "1",
+			wantVal: "1",
+		},
+		{
+			name:    "set without prefix",
+			key:     "sk8.B",
+			set:     true,
+			value:   "line 1\nline 2",
+			wantVal: "line 1\nline 2",
+		},
+		{
+			name:    "unset key",
+			key:     "sk8.C",
+			def:     "default",
+			wantVal: "default",
+		},
+		{
+			name: "OVF environment without vApp properties",
+			key:  ovfEnvGuestinfoKey,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.set {
+				if err := b.SetString(tc.key, tc.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var val string
+			if err := b.Batch(func() error {
+				var err error
+				val, err = b.String(tc.key, tc.def)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if val != tc.wantVal {
+				t.Errorf("String(%q) = %q, want %q", tc.key, val, tc.wantVal)
+			}
+		})
+	}
+}
+
+func TestVsphereBackendClose(t *testing.T) {
+	vm, cleanup := newTestVcsim(t)
+	defer cleanup()
+
+	b, err := newVsphereBackend(vm.Config.Uuid, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session is gone, so a request that requires one fails.
+	if _, err := methods.FindByUuid(b.ctx, b.client, &types.FindByUuid{
+		This:     *b.client.ServiceContent.SearchIndex,
+		Uuid:     vm.Config.Uuid,
+		VmSearch: true,
+	}); err == nil {
+		t.Error("request succeeded after Close, want NotAuthenticated")
+	}
+}
+
+func TestVappConfigSpec(t *testing.T) {
+	config := &types.VirtualMachineConfigInfo{
+		VAppConfig: &types.VmConfigInfo{
+			Property: []types.VAppPropertyInfo{
+				{Key: 1, Id: "A", Value: "1"},
+				{Key: 2, Id: "B", DefaultValue: "2"},
+			},
+			OvfEnvironmentTransport: []string{"com.vmware.guestInfo"},
+		},
+	}
+	env := func(a, b string) string {
+		return `<Environment ` +
+			`xmlns="http://schemas.dmtf.org/ovf/environment/1" ` +
+			`xmlns:oe="http://schemas.dmtf.org/ovf/environment/1">` +
+			`<PropertySection>` +
+			`<Property oe:key="A" oe:value="` + a + `"/>` +
+			`<Property oe:key="B" oe:value="` + b + `"/>` +
+			`</PropertySection></Environment>`
+	}
+
+	testCases := []struct {
+		name string
+		doc  string
+		want map[int32]string
+	}{
+		{
+			name: "unchanged",
+			doc:  env("1", ""),
+		},
+		{
+			name: "set to the empty string",
+			doc:  env("", ""),
+			want: map[int32]string{1: ""},
+		},
+		{
+			name: "set to the default value",
+			doc:  env("1", "2"),
+			want: map[int32]string{2: "2"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := vappConfigSpec(config, tc.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[int32]string{}
+			if spec != nil {
+				for _, p := range spec.Property {
+					if p.Operation != types.ArrayUpdateOperationEdit {
+						t.Errorf("operation = %s, want edit", p.Operation)
+						continue
+					}
+					got[p.Info.Key] = p.Info.Value
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("edits = %v, want %v", got, tc.want)
+			}
+			for k, v := range tc.want {
+				if gv, ok := got[k]; !ok || gv != v {
+					t.Errorf("edits = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
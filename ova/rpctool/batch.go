@@ -0,0 +1,249 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// getMany prints the values of several keys, read from either guestinfo
+// or the OVF environment, as a single document. All of the keys are
+// read in a single session and the OVF environment is parsed only once.
+func getMany(config Backend, args []string) error {
+	fs := newFlagSet("get-many")
+	source := fs.String(
+		"source", "guestinfo",
+		"The source of the values: \"guestinfo\" or \"ovf\".")
+	format := fs.String(
+		"format", "json",
+		"The format of the output: \"json\" or \"env\".")
+	addShowSecretsFlag(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *source, err = parseChoice(
+		"source", *source, "guestinfo", "ovf"); err != nil {
+		return err
+	}
+	if *format, err = parseChoice(
+		"format", *format, "json", "env"); err != nil {
+		return err
+	}
+	if len(keys) == 0 || (len(keys) == 1 && keys[0] == "-") {
+		stdin, err := readStdin()
+		if err != nil {
+			return err
+		}
+		keys = parseKeyList(stdin)
+	}
+
+	vals := map[string]string{}
+	if err := batch(config, func() error {
+		if *source == "ovf" {
+			ovfEnv, err := getOvfEnv(config)
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if vals[k], err = getDecodedOvfEnvProperty(ovfEnv, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, k := range keys {
+			val, err := config.String(guestinfoKey(k), "")
+			if err != nil {
+				return fmt.Errorf("failed to get %s: %v", guestinfoKey(k), err)
+			}
+			vals[k] = val
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for k, v := range vals {
+		vals[k] = redact(k, v)
+	}
+	return writeKeyVals(os.Stdout, *format, keys, vals)
+}
+
+// setMany sets the values of several keys, in either guestinfo or the
+// OVF environment, in a single session. When the source is the OVF
+// environment it is written only once.
+func setMany(config Backend, args []string) error {
+	fs := newFlagSet("set-many")
+	source := fs.String(
+		"source", "guestinfo",
+		"The destination of the values: \"guestinfo\" or \"ovf\".")
+	format := fs.String(
+		"format", "",
+		"The format of the document read from stdin: \"json\" or \"env\". "+
+			"The format is detected if omitted.")
+	pairs, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *source, err = parseChoice(
+		"source", *source, "guestinfo", "ovf"); err != nil {
+		return err
+	}
+
+	var (
+		keys []string
+		vals map[string]string
+	)
+	if len(pairs) == 0 || (len(pairs) == 1 && pairs[0] == "-") {
+		buf, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading stdin: %v", err)
+		}
+		if keys, vals, err = parseKeyValDoc("stdin", *format, buf); err != nil {
+			return err
+		}
+	} else {
+		vals = map[string]string{}
+		for _, p := range pairs {
+			i := strings.IndexByte(p, '=')
+			if i < 1 {
+				return fmt.Errorf("invalid KEY=VAL: %s", p)
+			}
+			if _, ok := vals[p[:i]]; !ok {
+				keys = append(keys, p[:i])
+			}
+			vals[p[:i]] = p[i+1:]
+		}
+	}
+
+	return batch(config, func() error {
+		if *source == "ovf" {
+			return setValuesInOvfEnv(keys, vals, config)
+		}
+		for _, k := range keys {
+			err := setKeepingRedacted(config, guestinfoKey(k), vals[k])
+			if err != nil {
+				return fmt.Errorf("failed to set %s: %v", guestinfoKey(k), err)
+			}
+		}
+		return nil
+	})
+}
+
+// parseKeyList returns the keys in the line-delimited list. Blank lines,
+// lines that begin with "#", and duplicate keys are ignored.
+func parseKeyList(s string) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		keys = append(keys, line)
+	}
+	return keys
+}
+
+// parseKeyValDoc parses a JSON object of string values or a document
+// of KEY=VAL lines. If format is empty then a document that begins with
+// "{" is treated as JSON.
+func parseKeyValDoc(
+	name, format string, buf []byte) ([]string, map[string]string, error) {
+
+	format, err := parseDocFormat(format, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == "env" {
+		return parseEnvDoc(name, buf)
+	}
+	var vals map[string]string
+	if err := json.Unmarshal(buf, &vals); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, vals, nil
+}
+
+// parseDocFormat returns the format of a document that is "json" or
+// "env". If format is empty then a document that begins with "{" is
+// treated as JSON.
+func parseDocFormat(format string, buf []byte) (string, error) {
+	if format == "" {
+		format = "env"
+		if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) {
+			format = "json"
+		}
+	}
+	return parseChoice("format", format, "json", "env")
+}
+
+// writeKeyVals writes the keys and their values, in order, as a JSON
+// object or as KEY=VAL lines that may be read by parseKeyValDoc.
+func writeKeyVals(
+	w io.Writer, format string, keys []string, vals map[string]string) error {
+
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		writeJSONObject(&buf, keys, func(k string) interface{} {
+			return vals[k]
+		})
+	case "env":
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%s=%s\n", k, quoteEnvFileValue(vals[k]))
+		}
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeJSONObject writes an indented JSON object with the keys in the
+// given order. Encoding/json would otherwise sort the keys of a map.
+func writeJSONObject(
+	buf *bytes.Buffer, keys []string, val func(string) interface{}) {
+
+	if len(keys) == 0 {
+		buf.WriteString("{}\n")
+		return
+	}
+	buf.WriteString("{\n")
+	for i, k := range keys {
+		jk, _ := json.Marshal(k)
+		jv, _ := json.MarshalIndent(val(k), "  ", "  ")
+		fmt.Fprintf(buf, "  %s: %s", jk, jv)
+		if i < len(keys)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+}
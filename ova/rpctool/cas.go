@@ -0,0 +1,263 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// generationSuffix is the suffix of the key that counts the writes
+	// made to KEY by cas, lock, and unlock.
+	generationSuffix = ".generation"
+
+	// ownerSuffix is the suffix of the key that records the owner of the
+	// lock on KEY.
+	ownerSuffix = ".owner"
+)
+
+// conflictError returns an exitCodeError with the status exitConflict.
+func conflictError(format string, args ...interface{}) error {
+	return exitCodeError{fmt.Errorf(format, args...), exitConflict}
+}
+
+// compareAndSwap sets key to value if the key's current value is equal to
+// expected, and increments the generation in genKey. The value and the
+// generation are read back after they are written to detect a concurrent
+// writer. Guestinfo has no atomic operations, so this narrows, but does
+// not close, the window in which two writers may both succeed. The file
+// backend closes it by locking the file for the session. A conflict is
+// returned as an error created by conflictError.
+func compareAndSwap(config Backend, key, genKey, expected, value string) error {
+	return batch(config, func() error {
+		cur, err := config.String(key, "")
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %v", key, err)
+		}
+		if cur != expected {
+			return conflictError(
+				"conflict: %s is %q, expected %q",
+				key, redact(key, cur), redact(key, expected))
+		}
+		gen, err := getGeneration(config, genKey)
+		if err != nil {
+			return err
+		}
+		if err := config.SetString(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+		if err := config.SetString(
+			genKey, strconv.FormatUint(gen+1, 10)); err != nil {
+			return fmt.Errorf("failed to set %s: %v", genKey, err)
+		}
+		if cur, err = config.String(key, ""); err != nil {
+			return fmt.Errorf("failed to get %s: %v", key, err)
+		}
+		next, err := getGeneration(config, genKey)
+		if err != nil {
+			return err
+		}
+		if cur != value || next != gen+1 {
+			return conflictError("conflict: %s was modified concurrently", key)
+		}
+		return nil
+	})
+}
+
+// getGeneration returns the generation stored in genKey, or zero if the
+// key is not set.
+func getGeneration(config Backend, genKey string) (uint64, error) {
+	val, err := config.String(genKey, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s: %v", genKey, err)
+	}
+	if val = strings.TrimSpace(val); val == "" {
+		return 0, nil
+	}
+	gen, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", genKey, val)
+	}
+	return gen, nil
+}
+
+// cas sets KEY to NEW if the current value of KEY is EXPECTED and, if
+// specified, the generation of KEY is GEN.
+func cas(config Backend, args []string) error {
+	fs := newFlagSet("cas")
+	generation := fs.String(
+		"generation", "",
+		"The expected generation of the key. The generation is not "+
+			"checked if omitted.")
+	args, err := parseLeadingFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("invalid number of arguments for cas")
+	}
+	var expectedGen uint64
+	if *generation != "" {
+		if expectedGen, err = strconv.ParseUint(
+			*generation, 10, 64); err != nil {
+			return fmt.Errorf("invalid generation: %s", *generation)
+		}
+	}
+	key := guestinfoKey(args[0])
+	genKey := key + generationSuffix
+	return batch(config, func() error {
+		if *generation != "" {
+			gen, err := getGeneration(config, genKey)
+			if err != nil {
+				return err
+			}
+			if gen != expectedGen {
+				return conflictError(
+					"conflict: %s is %d, expected %d", genKey, gen, expectedGen)
+			}
+		}
+		return compareAndSwap(config, key, genKey, args[1], args[2])
+	})
+}
+
+// lock acquires the advisory lock NAME by setting NAME.owner to the
+// owner, provided the lock is not held by another owner, and prints the
+// owner so it may be passed to unlock.
+func lock(config Backend, args []string) error {
+	fs := newFlagSet("lock")
+	owner := fs.String(
+		"owner", "",
+		"The owner of the lock. A lock held by OWNER is acquired again. "+
+			"Defaults to a new owner that is unique to this call.")
+	timeout := fs.Duration(
+		"timeout", 0,
+		"The maximum amount of time to wait for the lock. Zero fails "+
+			"immediately if the lock is held.")
+	interval := fs.Duration(
+		"interval", time.Second,
+		"The interval at which the lock is retried.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments for lock")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval: %s", *interval)
+	}
+	if *owner == "" {
+		if *owner, err = newLockOwner(); err != nil {
+			return err
+		}
+	}
+	name := guestinfoKey(args[0])
+	ownerKey := name + ownerSuffix
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		var cur string
+		err := batch(config, func() (err error) {
+			if cur, err = config.String(ownerKey, ""); err != nil {
+				return fmt.Errorf("failed to get %s: %v", ownerKey, err)
+			}
+			if cur != "" {
+				return nil
+			}
+			return compareAndSwap(
+				config, ownerKey, name+generationSuffix, "", *owner)
+		})
+		if cur == *owner || (cur == "" && err == nil) {
+			fmt.Println(*owner)
+			return nil
+		}
+		if _, ok := err.(exitCodeError); err != nil && !ok {
+			return err
+		}
+		if !time.Now().Add(*interval).Before(deadline) {
+			if cur == "" {
+				return err
+			}
+			return conflictError("conflict: %s is locked by %s", name, cur)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// unlock releases the advisory lock NAME if it is held by the owner.
+func unlock(config Backend, args []string) error {
+	fs := newFlagSet("unlock")
+	owner := fs.String(
+		"owner", "",
+		"The owner of the lock printed by lock.")
+	force := fs.Bool(
+		"force", false,
+		"Release the lock regardless of its owner.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments for unlock")
+	}
+	if *owner == "" && !*force {
+		return fmt.Errorf("unlock requires -owner or -force")
+	}
+	name := guestinfoKey(args[0])
+	ownerKey := name + ownerSuffix
+
+	expected := *owner
+	if *force {
+		if expected, err = config.String(ownerKey, ""); err != nil {
+			return fmt.Errorf("failed to get %s: %v", ownerKey, err)
+		}
+		if expected == "" {
+			return nil
+		}
+	}
+	err = compareAndSwap(config, ownerKey, name+generationSuffix, expected, "")
+	if _, ok := err.(exitCodeError); ok {
+		cur, _ := config.String(ownerKey, "")
+		if cur == "" {
+			return conflictError("conflict: %s is not locked", name)
+		}
+		return conflictError("conflict: %s is locked by %s", name, cur)
+	}
+	return err
+}
+
+// newLockOwner returns an owner that is unique to the caller: the VM's
+// BIOS UUID, or the host name outside of a VM, the process ID, and a
+// random token. A clone has the host name of the original VM until it is
+// renamed, and the processes in a VM share its UUID, so neither is unique
+// on its own.
+func newLockOwner() (string, error) {
+	id, err := readBIOSUUID(defaultDMIDir)
+	if err != nil {
+		if id, err = os.Hostname(); err != nil || id == "" {
+			id = "rpctool"
+		}
+	}
+	token := make([]byte, 4)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to create lock owner: %v", err)
+	}
+	return fmt.Sprintf("%s:%d:%x", id, os.Getpid(), token), nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+// checkConflict fails the test unless err is a conflict, or nil if
+// wantConflict is false.
+func checkConflict(t *testing.T, err error, wantConflict bool) {
+	t.Helper()
+	if !wantConflict {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if e, ok := err.(exitCodeError); !ok || e.code != exitConflict {
+		t.Fatalf("error = %v, want a conflict with status %d",
+			err, exitConflict)
+	}
+}
+
+func TestCas(t *testing.T) {
+	testCases := []struct {
+		name         string
+		args         []string
+		wantConflict bool
+		wantVal      string
+		wantGen      string
+	}{
+		{
+			name:    "expected value",
+			args:    []string{"sk8.A", "1", "2"},
+			wantVal: "2",
+			wantGen: "4",
+		},
+		{
+			name:         "unexpected value",
+			args:         []string{"sk8.A", "", "2"},
+			wantConflict: true,
+			wantVal:      "1",
+			wantGen:      "3",
+		},
+		{
+			name:    "expected generation",
+			args:    []string{"-generation", "3", "sk8.A", "1", "2"},
+			wantVal: "2",
+			wantGen: "4",
+		},
+		{
+			name:         "unexpected generation",
+			args:         []string{"-generation", "2", "sk8.A", "1", "2"},
+			wantConflict: true,
+			wantVal:      "1",
+			wantGen:      "3",
+		},
+		{
+			name:    "unset key",
+			args:    []string{"-generation", "0", "sk8.B", "", "1"},
+			wantVal: "1",
+			wantGen: "1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newMemoryBackend()
+			b.SetString("guestinfo.sk8.A", "1")
+			b.SetString("guestinfo.sk8.A.generation", "3")
+			checkConflict(t, cas(b, tc.args), tc.wantConflict)
+
+			key := guestinfoKey(tc.args[len(tc.args)-3])
+			if val, _ := b.String(key, ""); val != tc.wantVal {
+				t.Errorf("%s = %q, want %q", key, val, tc.wantVal)
+			}
+			genKey := key + generationSuffix
+			if gen, _ := b.String(genKey, ""); gen != tc.wantGen {
+				t.Errorf("%s = %q, want %q", genKey, gen, tc.wantGen)
+			}
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	const ownerKey = "guestinfo.sk8.L.owner"
+	testCases := []struct {
+		name         string
+		owner        string
+		lock         []string
+		unlock       []string
+		wantConflict bool
+		wantErr      bool
+		wantOwner    string
+	}{
+		{
+			name:      "unlocked",
+			lock:      []string{"sk8.L"},
+			wantOwner: "new",
+		},
+		{
+			name:         "held by a default owner",
+			owner:        "new",
+			lock:         []string{"sk8.L"},
+			wantConflict: true,
+			wantOwner:    "held",
+		},
+		{
+			name:      "held by the same explicit owner",
+			owner:     "a",
+			lock:      []string{"-owner", "a", "sk8.L"},
+			wantOwner: "a",
+		},
+		{
+			name:  "held by another explicit owner",
+			owner: "a",
+			lock: []string{"-owner", "b",
+				"-timeout", "10ms", "-interval", "5ms", "sk8.L"},
+			wantConflict: true,
+			wantOwner:    "a",
+		},
+		{
+			name:   "unlock by the owner",
+			owner:  "a",
+			unlock: []string{"-owner", "a", "sk8.L"},
+		},
+		{
+			name:         "unlock by another owner",
+			owner:        "a",
+			unlock:       []string{"-owner", "b", "sk8.L"},
+			wantConflict: true,
+			wantOwner:    "a",
+		},
+		{
+			name:      "unlock without an owner",
+			owner:     "a",
+			unlock:    []string{"sk8.L"},
+			wantErr:   true,
+			wantOwner: "a",
+		},
+		{
+			name:   "unlock with -force",
+			owner:  "a",
+			unlock: []string{"-force", "sk8.L"},
+		},
+		{
+			name:         "unlock when not locked",
+			unlock:       []string{"-owner", "a", "sk8.L"},
+			wantConflict: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newMemoryBackend()
+			held := ""
+			if tc.owner == "new" {
+				if err := lock(b, []string{"sk8.L"}); err != nil {
+					t.Fatal(err)
+				}
+				held, _ = b.String(ownerKey, "")
+			} else if tc.owner != "" {
+				b.SetString(ownerKey, tc.owner)
+			}
+
+			var err error
+			if tc.lock != nil {
+				err = lock(b, tc.lock)
+			} else {
+				err = unlock(b, tc.unlock)
+			}
+			if tc.wantErr {
+				if _, ok := err.(exitCodeError); err == nil || ok {
+					t.Fatalf("error = %v, want a usage error", err)
+				}
+			} else {
+				checkConflict(t, err, tc.wantConflict)
+			}
+
+			owner, _ := b.String(ownerKey, "")
+			switch tc.wantOwner {
+			case "new":
+				if owner == "" || owner == held {
+					t.Errorf("owner = %q, want a new owner", owner)
+				}
+			case "held":
+				if owner != held {
+					t.Errorf("owner = %q, want %q", owner, held)
+				}
+			default:
+				if owner != tc.wantOwner {
+					t.Errorf("owner = %q, want %q", owner, tc.wantOwner)
+				}
+			}
+		})
+	}
+}
+
+func TestNewLockOwner(t *testing.T) {
+	a, err := newLockOwner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newLockOwner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == "" || a == b {
+		t.Errorf("newLockOwner() = %q, %q, want unique owners", a, b)
+	}
+}
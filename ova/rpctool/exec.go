@@ -0,0 +1,127 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execWithConfig resolves the keys selected by the flags and runs the
+// command with the keys that are set added to its environment.
+func execWithConfig(config Backend, args []string) error {
+	fs := newFlagSet("exec")
+	rf := addResolverFlags(fs)
+	keyList := fs.String(
+		"keys", "",
+		"A comma-separated list of the keys to set in the environment.")
+	manifest := fs.String(
+		"manifest", "",
+		"A file with the keys to set in the environment, one per line.")
+	prefix := fs.String(
+		"prefix", "",
+		"Select the keys in the manifest that begin with the prefix. The "+
+			"prefix is part of the variable names. The manifest defaults "+
+			"to "+defaultManifest+".")
+	df := addDecryptFlags(fs)
+	cmdArgs, err := parseLeadingFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(cmdArgs) == 0 {
+		return fmt.Errorf("invalid number of arguments for exec")
+	}
+	df.apply(config)
+
+	keys := parseKeyList(strings.Replace(*keyList, ",", "\n", -1))
+	if *prefix != "" && *manifest == "" {
+		*manifest = defaultManifest
+	}
+	if *manifest != "" {
+		buf, err := ioutil.ReadFile(*manifest)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %v", err)
+		}
+		for _, k := range parseKeyList(string(buf)) {
+			if strings.HasPrefix(k, *prefix) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	keys = uniqueStrings(keys)
+	if len(keys) == 0 {
+		return fmt.Errorf("exec requires -keys, -manifest, or -prefix")
+	}
+	for _, k := range keys {
+		if !envNameRx.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name: %s", k)
+		}
+	}
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+
+	vals := map[string]string{}
+	if err := batch(config, func() error {
+		for _, k := range keys {
+			rv, err := r.resolve(k)
+			if err != nil {
+				return err
+			}
+			if rv.Source != "" {
+				vals[k] = strings.TrimRight(rv.Value, "\n")
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(cmdArgs[0])
+	if err != nil {
+		return err
+	}
+	// The command replaces the program, so the deferred hooks never run.
+	runExitHooks()
+	return execve(path, cmdArgs, mergeEnv(os.Environ(), keys, vals))
+}
+
+// mergeEnv returns the environment env with the values of the keys set.
+// A variable that is already in env is replaced in place, and the others
+// are appended in the order of the keys. Keys that have no value do not
+// replace the inherited environment.
+func mergeEnv(env, keys []string, vals map[string]string) []string {
+	merged := make([]string, 0, len(env)+len(keys))
+	set := map[string]bool{}
+	for _, kv := range env {
+		if j := strings.IndexByte(kv, '='); j > 0 {
+			if v, ok := vals[kv[:j]]; ok {
+				kv = kv[:j+1] + v
+				set[kv[:j]] = true
+			}
+		}
+		merged = append(merged, kv)
+	}
+	for _, k := range keys {
+		if v, ok := vals[k]; ok && !set[k] {
+			merged = append(merged, k+"="+v)
+		}
+	}
+	return merged
+}
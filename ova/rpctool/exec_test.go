@@ -0,0 +1,91 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	testCases := []struct {
+		name string
+		env  []string
+		keys []string
+		vals map[string]string
+		want []string
+	}{
+		{
+			name: "append in key order",
+			env:  []string{"HOME=/root"},
+			keys: []string{"B", "A"},
+			vals: map[string]string{"A": "1", "B": "2"},
+			want: []string{"HOME=/root", "B=2", "A=1"},
+		},
+		{
+			name: "replace in place",
+			env:  []string{"A=0", "HOME=/root"},
+			keys: []string{"A"},
+			vals: map[string]string{"A": "1"},
+			want: []string{"A=1", "HOME=/root"},
+		},
+		{
+			name: "unset key keeps the inherited value",
+			env:  []string{"A=0"},
+			keys: []string{"A", "B"},
+			vals: map[string]string{},
+			want: []string{"A=0"},
+		},
+		{
+			name: "empty value",
+			env:  []string{"A=0"},
+			keys: []string{"A"},
+			vals: map[string]string{"A": ""},
+			want: []string{"A="},
+		},
+		{
+			name: "value with an equals sign",
+			keys: []string{"A"},
+			vals: map[string]string{"A": "x=y"},
+			want: []string{"A=x=y"},
+		},
+		{
+			name: "variable with a prefix of a key",
+			env:  []string{"AB=0"},
+			keys: []string{"A"},
+			vals: map[string]string{"A": "1"},
+			want: []string{"AB=0", "A=1"},
+		},
+		{
+			name: "entries without a name are kept",
+			env:  []string{"=C:=C:\\", "A=0"},
+			keys: []string{"A"},
+			vals: map[string]string{"A": "1"},
+			want: []string{"=C:=C:\\", "A=1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := append([]string(nil), tc.env...)
+			got := mergeEnv(env, tc.keys, tc.vals)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("mergeEnv = %q, want %q", got, tc.want)
+			}
+			if !reflect.DeepEqual(env, tc.env) {
+				t.Errorf("mergeEnv modified env: %q", env)
+			}
+		})
+	}
+}
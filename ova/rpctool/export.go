@@ -0,0 +1,150 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// exportConfig resolves the keys from the arguments and the manifest and
+// prints them as a shell script, a systemd EnvironmentFile, JSON, or YAML.
+func exportConfig(config Backend, args []string) error {
+	fs := newFlagSet("export")
+	rf := addResolverFlags(fs)
+	manifest := fs.String(
+		"manifest", "",
+		"A file with the keys to export, one per line. The keys are "+
+			"exported after any KEY arguments. If \"-\" then the keys are "+
+			"read from stdin.")
+	format := fs.String(
+		"format", "sh",
+		"The format of the output: \"sh\", \"systemd\", \"json\", or \"yaml\".")
+	comments := fs.Bool(
+		"comments", false,
+		"Record the source of each value. JSON values become objects "+
+			"with the fields \"value\" and \"source\".")
+	all := fs.Bool(
+		"all", false,
+		"Export keys that are unset as empty values.")
+	omitSecrets := fs.Bool(
+		"omit-secrets", false,
+		"Omit the sensitive keys instead of redacting them.")
+	addShowSecretsFlag(fs)
+	df := addDecryptFlags(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	df.apply(config)
+	if *format, err = parseChoice(
+		"format", *format, "sh", "systemd", "json", "yaml"); err != nil {
+		return err
+	}
+	if *manifest != "" {
+		var buf []byte
+		if *manifest == "-" {
+			buf, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			buf, err = ioutil.ReadFile(*manifest)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %v", err)
+		}
+		keys = append(keys, parseKeyList(string(buf))...)
+	}
+	keys = uniqueStrings(keys)
+	if len(keys) == 0 {
+		return fmt.Errorf("invalid number of arguments for export")
+	}
+	if *format == "sh" || *format == "systemd" {
+		for _, k := range keys {
+			if !envNameRx.MatchString(k) {
+				return fmt.Errorf("invalid environment variable name: %s", k)
+			}
+		}
+	}
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+
+	var (
+		set  []string
+		vals = map[string]resolvedValue{}
+	)
+	if err := batch(config, func() error {
+		for _, k := range keys {
+			if *omitSecrets && sensitive.match(k) {
+				continue
+			}
+			rv, err := r.resolve(k)
+			if err != nil {
+				return err
+			}
+			if rv.Source != "" || *all {
+				rv.Value = redact(k, rv.Value)
+				set = append(set, k)
+				vals[k] = rv
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch *format {
+	case "json":
+		writeJSONObject(&buf, set, func(k string) interface{} {
+			if *comments {
+				return vals[k]
+			}
+			return vals[k].Value
+		})
+	default:
+		for _, k := range set {
+			if *comments {
+				fmt.Fprintf(&buf, "# %s\n", sourceOrUnset(vals[k]))
+			}
+			switch *format {
+			case "sh":
+				fmt.Fprintf(&buf, "%s=%s\n", k, shellQuote(vals[k].Value))
+			case "systemd":
+				fmt.Fprintf(&buf, "%s=%s\n", k, systemdQuote(vals[k].Value))
+			case "yaml":
+				fmt.Fprintf(&buf, "%s: %s\n", yamlKey(k), yamlQuote(vals[k].Value))
+			}
+		}
+	}
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// uniqueStrings returns the strings without duplicates, in the order in
+// which they first appear.
+func uniqueStrings(strs []string) []string {
+	var uniq []string
+	seen := map[string]bool{}
+	for _, s := range strs {
+		if !seen[s] {
+			seen[s] = true
+			uniq = append(uniq, s)
+		}
+	}
+	return uniq
+}
@@ -0,0 +1,137 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// newFlagSet returns a flag set for the command with the given name.
+func newFlagSet(cmdName string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// flagError is an error that was already reported, along with the
+// command's usage, while parsing the command's flags.
+type flagError struct {
+	error
+}
+
+// exitCodeError is an error that causes the program to exit with a
+// specific status so that scripts may distinguish it from other errors.
+type exitCodeError struct {
+	error
+	code int
+}
+
+const (
+	// exitTimeout is the exit status when a wait times out.
+	exitTimeout = 2
+
+	// exitConflict is the exit status when a compare-and-swap or a lock
+	// fails because of another writer.
+	exitConflict = 3
+)
+
+// parseFlags parses the command's flags and returns its positional
+// arguments. Unlike flag.FlagSet.Parse, flags may appear after the
+// positional arguments. All arguments after "--" are positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, flagError{err}
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return pos, nil
+		}
+		if i := len(args) - len(rest); i > 0 && args[i-1] == "--" {
+			return append(pos, rest...), nil
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parseLeadingFlags is like parseFlags except flags must appear before
+// the positional arguments. It is used by commands whose positional
+// arguments may begin with "-".
+func parseLeadingFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, err
+		}
+		return nil, flagError{err}
+	}
+	return fs.Args(), nil
+}
+
+// parseChoice returns the element of choices that case-insensitively
+// matches val.
+func parseChoice(name, val string, choices ...string) (string, error) {
+	for _, c := range choices {
+		if strings.EqualFold(val, c) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s: %s", name, val)
+}
+
+// exitHooks are run by exit before the program exits, ex. to close the
+// backend.
+var exitHooks []func()
+
+// runExitHooks runs the exitHooks once.
+func runExitHooks() {
+	hooks := exitHooks
+	exitHooks = nil
+	for _, h := range hooks {
+		h()
+	}
+}
+
+// exit runs the exitHooks and exits the program with the status code.
+func exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
+
+// exitOnError exits the program if err is not nil. The error is printed
+// to stderr unless it was already reported by parseFlags.
+func exitOnError(err error) {
+	switch e := err.(type) {
+	case nil:
+		return
+	case flagError:
+	case exitCodeError:
+		fmt.Fprintln(os.Stderr, e.error)
+		exit(e.code)
+	default:
+		if err == flag.ErrHelp {
+			exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+	}
+	exit(1)
+}
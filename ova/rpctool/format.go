@@ -0,0 +1,77 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+var (
+	// envNameRx matches a valid POSIX shell variable name.
+	envNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// yamlPlainKeyRx matches a mapping key that need not be quoted.
+	yamlPlainKeyRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+	// yamlReservedRx matches the plain scalars YAML 1.1 does not treat
+	// as strings.
+	yamlReservedRx = regexp.MustCompile(
+		`^(?i:y|yes|n|no|true|false|on|off|null)$`)
+)
+
+// shellQuote returns s as a single-quoted POSIX shell word. Nothing
+// inside single quotes is expanded, so the value is safe to source.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// systemdQuote returns s as a double-quoted value for a systemd
+// EnvironmentFile. The characters systemd unescapes inside double quotes
+// are escaped with a backslash. The result is also a valid POSIX shell
+// double-quoted word.
+func systemdQuote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '`', '$':
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// yamlQuote returns s as a YAML double-quoted scalar. The escape
+// sequences used by a JSON string are a subset of YAML's.
+func yamlQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// yamlKey returns s as a YAML mapping key, quoting it only if necessary.
+func yamlKey(s string) string {
+	if yamlPlainKeyRx.MatchString(s) && !yamlReservedRx.MatchString(s) {
+		return s
+	}
+	return yamlQuote(s)
+}
@@ -0,0 +1,139 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testQuoteValues are values with the characters that must be quoted.
+var testQuoteValues = []string{
+	"",
+	"plain",
+	"two words",
+	"it's",
+	`say "hi"`,
+	`C:\path`,
+	"$HOME `id` $(id)",
+	"line 1\nline 2\n",
+	"tab\there",
+	"ü✓",
+	"null",
+	"- a: b # c",
+}
+
+func TestShellQuote(t *testing.T) {
+	testCases := []struct {
+		val  string
+		want string
+	}{
+		{val: "", want: "''"},
+		{val: "a b", want: "'a b'"},
+		{val: "it's", want: `'it'\''s'`},
+		{val: "$HOME", want: "'$HOME'"},
+	}
+	for _, tc := range testCases {
+		if got := shellQuote(tc.val); got != tc.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tc.val, got, tc.want)
+		}
+	}
+	testShellRoundTrip(t, "shellQuote", shellQuote)
+}
+
+func TestSystemdQuote(t *testing.T) {
+	testCases := []struct {
+		val  string
+		want string
+	}{
+		{val: "", want: `""`},
+		{val: "a b", want: `"a b"`},
+		{val: "it's", want: `"it's"`},
+		{val: `a"b\c`, want: `"a\"b\\c"`},
+		{val: "$HOME `id`", want: "\"\\$HOME \\`id\\`\""},
+	}
+	for _, tc := range testCases {
+		if got := systemdQuote(tc.val); got != tc.want {
+			t.Errorf("systemdQuote(%q) = %s, want %s", tc.val, got, tc.want)
+		}
+	}
+	testShellRoundTrip(t, "systemdQuote", systemdQuote)
+}
+
+// testShellRoundTrip checks that the shell reads each of testQuoteValues
+// quoted by quote as the original value.
+func testShellRoundTrip(t *testing.T, name string, quote func(string) string) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	for _, val := range testQuoteValues {
+		out, err := exec.Command(sh, "-c", "printf '%s' "+quote(val)).Output()
+		if err != nil {
+			t.Fatalf("%s(%q): %v", name, val, err)
+		}
+		if string(out) != val {
+			t.Errorf("%s(%q): sh read %q", name, val, out)
+		}
+	}
+}
+
+func TestYamlQuote(t *testing.T) {
+	testCases := []struct {
+		val  string
+		want string
+	}{
+		{val: "", want: `""`},
+		{val: "null", want: `"null"`},
+		{val: "a\nb", want: `"a\nb"`},
+		{val: "<&>", want: `"<&>"`},
+	}
+	for _, tc := range testCases {
+		if got := yamlQuote(tc.val); got != tc.want {
+			t.Errorf("yamlQuote(%q) = %s, want %s", tc.val, got, tc.want)
+		}
+	}
+	for _, val := range testQuoteValues {
+		var m map[string]string
+		doc := yamlKey(val) + ": " + yamlQuote(val)
+		if err := yaml.Unmarshal([]byte(doc), &m); err != nil {
+			t.Fatalf("%s: %v", doc, err)
+		}
+		if got, ok := m[val]; !ok || got != val {
+			t.Errorf("%s: read %v", doc, m)
+		}
+	}
+}
+
+func TestYamlKey(t *testing.T) {
+	testCases := []struct {
+		key  string
+		want string
+	}{
+		{key: "NUM_NODES", want: "NUM_NODES"},
+		{key: "local-hostname", want: "local-hostname"},
+		{key: "yes", want: `"yes"`},
+		{key: "Null", want: `"Null"`},
+		{key: "1a", want: `"1a"`},
+		{key: "a b", want: `"a b"`},
+	}
+	for _, tc := range testCases {
+		if got := yamlKey(tc.key); got != tc.want {
+			t.Errorf("yamlKey(%q) = %s, want %s", tc.key, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// keySchema is the single source of the sk8 configuration keys. It is
+// read from ova/sk8-config-keys.json and used to generate the OVF
+// ProductSection, the sk8 key manifest, and rpctool's list of the
+// sensitive keys.
+type keySchema struct {
+	Product keySchemaProduct `json:"product"`
+	Keys    []keySchemaEntry `json:"keys"`
+}
+
+// keySchemaProduct describes the product in the ProductSection.
+type keySchemaProduct struct {
+	Info       string `json:"info"`
+	Product    string `json:"product"`
+	Vendor     string `json:"vendor"`
+	ProductURL string `json:"productUrl"`
+	VendorURL  string `json:"vendorUrl"`
+}
+
+// keySchemaEntry is an sk8 configuration key. A key with a type is also
+// an OVF property. A key without a type appears only in the manifest. A
+// key that is ovfOnly is read from the OVF environment by the OVA's
+// scripts, but not by sk8.sh, so it does not appear in the manifest.
+type keySchemaEntry struct {
+	Key              string `json:"key"`
+	Type             string `json:"type"`
+	Qualifiers       string `json:"qualifiers"`
+	Default          string `json:"default"`
+	Category         string `json:"category"`
+	Label            string `json:"label"`
+	Description      string `json:"description"`
+	Sensitive        bool   `json:"sensitive"`
+	UserConfigurable bool   `json:"userConfigurable"`
+	OvfOnly          bool   `json:"ovfOnly"`
+}
+
+// readKeySchema reads and checks the key schema at path.
+func readKeySchema(path string) (*keySchema, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	var s keySchema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	seen := map[string]bool{}
+	for i, e := range s.Keys {
+		switch {
+		case !envNameRx.MatchString(e.Key):
+			return nil, fmt.Errorf("%s: keys[%d]: invalid key %q", path, i, e.Key)
+		case seen[e.Key]:
+			return nil, fmt.Errorf("%s: %s: duplicate key", path, e.Key)
+		case e.Type != "" && !supportedOvfType(e.Type):
+			return nil, fmt.Errorf(
+				"%s: %s: unsupported type %q", path, e.Key, e.Type)
+		case e.Type == "" && (e.Label != "" || e.Description != "" ||
+			e.Qualifiers != "" || e.Default != "" || e.Category != "" ||
+			e.OvfOnly):
+			return nil, fmt.Errorf(
+				"%s: %s: OVF fields require a type", path, e.Key)
+		}
+		seen[e.Key] = true
+	}
+	return &s, nil
+}
+
+// productSection returns the ProductSection for the schema. The
+// ProductSection is a fragment that the ova Makefile appends to the
+// VirtualSystem of photon.ovf and centos.ovf.
+func (s *keySchema) productSection() string {
+	var buf bytes.Buffer
+	buf.WriteString("\n    <ProductSection>")
+	for _, e := range []struct{ name, val string }{
+		{"Info", s.Product.Info},
+		{"Product", s.Product.Product},
+		{"Vendor", s.Product.Vendor},
+		{"ProductUrl", s.Product.ProductURL},
+		{"VendorUrl", s.Product.VendorURL},
+	} {
+		if e.val != "" {
+			fmt.Fprintf(&buf, "\n      <%[1]s>%[2]s</%[1]s>",
+				e.name, escapeXMLText(e.val))
+		}
+	}
+	var category string
+	for _, e := range s.Keys {
+		if e.Type == "" {
+			continue
+		}
+		if e.Category != "" && e.Category != category {
+			fmt.Fprintf(&buf, "\n      <Category>%s</Category>",
+				escapeXMLText(e.Category))
+			category = e.Category
+		}
+		buf.WriteString("\n      <Property")
+		if e.Qualifiers != "" {
+			writeXMLAttr(&buf, "ovf:qualifiers", e.Qualifiers)
+		}
+		writeXMLAttr(
+			&buf, "ovf:userConfigurable", strconv.FormatBool(e.UserConfigurable))
+		if e.Sensitive {
+			writeXMLAttr(&buf, "ovf:password", "true")
+		}
+		writeXMLAttr(&buf, "ovf:value", e.Default)
+		writeXMLAttr(&buf, "ovf:type", e.Type)
+		writeXMLAttr(&buf, "ovf:key", e.Key)
+		if e.Label == "" && e.Description == "" {
+			buf.WriteString("/>")
+			continue
+		}
+		buf.WriteString(">")
+		if e.Label != "" {
+			fmt.Fprintf(&buf, "\n        <Label>%s</Label>",
+				escapeXMLText(e.Label))
+		}
+		if e.Description != "" {
+			fmt.Fprintf(&buf, "\n        <Description>%s</Description>",
+				escapeXMLText(e.Description))
+		}
+		buf.WriteString("\n      </Property>")
+	}
+	buf.WriteString("\n    </ProductSection>")
+	return buf.String()
+}
+
+// manifest returns the sk8 key manifest for the schema, one key per line.
+// The manifest lists the keys read by sk8.sh.
+func (s *keySchema) manifest() string {
+	var buf bytes.Buffer
+	for _, e := range s.Keys {
+		if !e.OvfOnly {
+			fmt.Fprintln(&buf, e.Key)
+		}
+	}
+	return buf.String()
+}
+
+// ovfOnlyKeys returns the keys that are ovfOnly.
+func (s *keySchema) ovfOnlyKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, e := range s.Keys {
+		if e.OvfOnly {
+			keys[e.Key] = true
+		}
+	}
+	return keys
+}
+
+// manifestOnlyKeys returns the keys that have no type, which are not
+// OVF properties.
+func (s *keySchema) manifestOnlyKeys() map[string]bool {
+	keys := map[string]bool{}
+	for _, e := range s.Keys {
+		if e.Type == "" {
+			keys[e.Key] = true
+		}
+	}
+	return keys
+}
+
+// goSource returns the Go source of rpctool's list of the keys that the
+// schema marks as sensitive. The list is added to defaultSensitiveKeys so
+// the keys are redacted and scrubbed by default.
+func (s *keySchema) goSource(name string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"rpctool gen.ovf\" from %s. DO NOT EDIT.\n\n",
+		filepath.Base(name))
+	buf.WriteString("package main\n\n")
+	buf.WriteString("// schemaSensitiveKeys are the keys that the sk8 key schema marks as\n")
+	buf.WriteString("// sensitive.\n")
+	buf.WriteString("var schemaSensitiveKeys = sensitiveKeys{\n")
+	for _, e := range s.Keys {
+		if e.Sensitive {
+			fmt.Fprintf(&buf, "\t%s,\n", strconv.Quote(e.Key))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// escapeXMLText escapes the characters that may not appear in an
+// element's text. Unlike xml.EscapeText, line breaks are preserved so
+// multi-line descriptions remain readable.
+func escapeXMLText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// generateOvf writes the ProductSection and the key manifest generated
+// from the key schema.
+func generateOvf(args []string) error {
+	fs := newFlagSet("gen.ovf")
+	ovfFile := fs.String(
+		"ovf", "",
+		"The file to which the ProductSection is written. The "+
+			"ProductSection is written to stdout if omitted.")
+	manifestFile := fs.String(
+		"manifest", "",
+		"The file to which the key manifest is written.")
+	goFile := fs.String(
+		"go", "",
+		"The file to which the Go source of rpctool's list of the "+
+			"sensitive keys is written.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments for gen.ovf")
+	}
+	s, err := readKeySchema(args[0])
+	if err != nil {
+		return err
+	}
+	if *manifestFile != "" {
+		if err := writeFileAtomic(
+			*manifestFile, []byte(s.manifest()), 0644); err != nil {
+			return err
+		}
+	}
+	if *goFile != "" {
+		if err := writeFileAtomic(
+			*goFile, []byte(s.goSource(args[0])), 0644); err != nil {
+			return err
+		}
+	}
+	if *ovfFile == "" {
+		_, err := fmt.Fprintln(os.Stdout, s.productSection())
+		return err
+	}
+	return writeFileAtomic(*ovfFile, []byte(s.productSection()), 0644)
+}
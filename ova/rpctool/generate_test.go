@@ -0,0 +1,82 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestGeneratedFiles checks that the files generated from the key schema
+// by "make generate" are up to date.
+func TestGeneratedFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	testCases := []struct {
+		flag      string
+		committed string
+	}{
+		{flag: "-ovf", committed: "../ovf/product-section.ovf"},
+		{flag: "-manifest", committed: "../sk8-config-keys.env"},
+		{flag: "-go", committed: "sensitive_schema.go"},
+	}
+	var args []string
+	for _, tc := range testCases {
+		args = append(args,
+			tc.flag, filepath.Join(dir, filepath.Base(tc.committed)))
+	}
+	if err := generateOvf(
+		append(args, "../sk8-config-keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range testCases {
+		want, err := ioutil.ReadFile(tc.committed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(
+			filepath.Join(dir, filepath.Base(tc.committed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date, run \"make -C ova generate\"",
+				tc.committed)
+		}
+	}
+}
+
+func TestReadKeySchemaInvalid(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "schema.json")
+	for _, doc := range []string{
+		`{"keys": [{"key": "1A"}]}`,
+		`{"keys": [{"key": "A"}, {"key": "A"}]}`,
+		`{"keys": [{"key": "A", "type": "int"}]}`,
+		`{"keys": [{"key": "A", "label": "A"}]}`,
+		`{"keys": [{"key": "A", "ovfOnly": true}]}`,
+		`{"keys": [{"key": "A", "unknown": true}]}`,
+	} {
+		if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readKeySchema(path); err == nil {
+			t.Errorf("readKeySchema(%s) succeeded, want error", doc)
+		}
+	}
+}
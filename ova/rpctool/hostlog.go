@@ -0,0 +1,128 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// logLevels are the sk8 log levels in order of increasing severity. They
+// match the levels printed by the sk8 scripts' log function.
+var logLevels = []string{"debug", "info", "warn", "error", "fatal"}
+
+// logLevel returns the severity of the level, or -1 if the level is not
+// one of logLevels.
+func logLevel(level string) int {
+	for i, l := range logLevels {
+		if strings.EqualFold(level, l) {
+			return i
+		}
+	}
+	return -1
+}
+
+// logToHost writes a message, or the sk8 log messages read from stdin,
+// to the VM's log file on the host.
+func logToHost(config Backend, args []string) error {
+	fs := newFlagSet("log")
+	level := fs.String(
+		"level", "info",
+		"The level of the message: \"debug\", \"info\", \"warn\", "+
+			"\"error\", or \"fatal\". With -tee-host-log the minimum "+
+			"level of the forwarded messages.")
+	tag := fs.String(
+		"tag", "sk8",
+		"The tag that prefixes each message in the host log.")
+	tee := fs.Bool(
+		"tee-host-log", false,
+		"Copy stdin to stdout and forward the sk8 log messages in it to "+
+			"the host log.")
+	all := fs.Bool(
+		"all", false,
+		"With -tee-host-log, forward every line instead of only the sk8 "+
+			"log messages.")
+	args, err := parseLeadingFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *level, err = parseChoice("level", *level, logLevels...); err != nil {
+		return err
+	}
+
+	if *tee {
+		if len(args) != 0 {
+			return fmt.Errorf("invalid number of arguments for log")
+		}
+		return teeHostLog(
+			config, os.Stdin, os.Stdout, *tag, logLevel(*level), *all)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("invalid number of arguments for log")
+	}
+
+	// The host log is line-oriented, so each line is a message.
+	for _, line := range strings.Split(strings.Join(args, " "), "\n") {
+		if line == "" {
+			continue
+		}
+		msg := fmt.Sprintf("%s: %s %s", *tag, strings.ToUpper(*level), line)
+		if err := hostLog(config, msg); err != nil {
+			return fmt.Errorf("failed to write to the host log: %v", err)
+		}
+	}
+	return nil
+}
+
+// teeHostLog copies r to w and forwards the lines that begin with an sk8
+// log level of at least minLevel, or every line if all is true, to the
+// host log. The copy continues if the host log fails so that the program
+// may be used in a pipeline without interrupting it.
+func teeHostLog(
+	config Backend, r io.Reader, w io.Writer,
+	tag string, minLevel int, all bool) error {
+
+	br := bufio.NewReader(r)
+	forward := true
+	for {
+		line, readErr := br.ReadString('\n')
+		if line != "" {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+			msg := strings.TrimRight(line, "\r\n")
+			fields := strings.Fields(msg)
+			send := all && msg != ""
+			if len(fields) > 0 && logLevel(fields[0]) >= minLevel {
+				send = true
+			}
+			if forward && send {
+				if err := hostLog(config, tag+": "+msg); err != nil {
+					fmt.Fprintf(os.Stderr,
+						"warning: failed to write to the host log: %v\n", err)
+					forward = false
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		} else if readErr != nil {
+			return readErr
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHostLogger is a Backend that records the messages written to the
+// host log. After failAfter messages, if not zero, writes fail.
+type testHostLogger struct {
+	Backend
+	msgs      []string
+	calls     int
+	failAfter int
+}
+
+func (l *testHostLogger) HostLog(msg string) error {
+	l.calls++
+	if l.failAfter > 0 && len(l.msgs) >= l.failAfter {
+		return errors.New("host log unavailable")
+	}
+	l.msgs = append(l.msgs, msg)
+	return nil
+}
+
+func TestTeeHostLog(t *testing.T) {
+	const input = "INFO starting\n" +
+		"plain output\n" +
+		"debug details\n" +
+		"\n" +
+		"WARN disk is slow\r\n" +
+		"error failed"
+
+	testCases := []struct {
+		name      string
+		minLevel  int
+		all       bool
+		failAfter int
+		want      []string
+	}{
+		{
+			name:     "info",
+			minLevel: logLevel("info"),
+			want: []string{
+				"sk8: INFO starting",
+				"sk8: WARN disk is slow",
+				"sk8: error failed",
+			},
+		},
+		{
+			name:     "error",
+			minLevel: logLevel("error"),
+			want:     []string{"sk8: error failed"},
+		},
+		{
+			name:     "all",
+			minLevel: logLevel("error"),
+			all:      true,
+			want: []string{
+				"sk8: INFO starting",
+				"sk8: plain output",
+				"sk8: debug details",
+				"sk8: WARN disk is slow",
+				"sk8: error failed",
+			},
+		},
+		{
+			name:      "host log fails",
+			minLevel:  logLevel("info"),
+			failAfter: 1,
+			want:      []string{"sk8: INFO starting"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &testHostLogger{
+				Backend: newMemoryBackend(), failAfter: tc.failAfter}
+			var out bytes.Buffer
+			if err := teeHostLog(
+				&valueBackend{Backend: l}, strings.NewReader(input), &out,
+				"sk8", tc.minLevel, tc.all); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != input {
+				t.Errorf("output = %q, want %q", out.String(), input)
+			}
+			if !reflect.DeepEqual(l.msgs, tc.want) {
+				t.Errorf("host log = %q, want %q", l.msgs, tc.want)
+			}
+			// A failed write stops the forwarding, but not the copy.
+			if tc.failAfter > 0 && l.calls != tc.failAfter+1 {
+				t.Errorf("host log written %d times, want %d",
+					l.calls, tc.failAfter+1)
+			}
+		})
+	}
+}
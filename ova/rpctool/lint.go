@@ -0,0 +1,192 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// defaultManifest is the sk8 key manifest installed with sk8.
+const defaultManifest = "/var/lib/sk8/sk8-config-keys.env"
+
+// linter collects the problems found by lintOvf.
+type linter struct {
+	strict   bool
+	errors   int
+	warnings int
+}
+
+// errorf reports a problem that causes the lint to fail.
+func (l *linter) errorf(file, key, format string, args ...interface{}) {
+	l.errors++
+	fmt.Fprintf(
+		os.Stderr, "%s: %s: %s\n", file, key, fmt.Sprintf(format, args...))
+}
+
+// warnf reports a problem that causes the lint to fail only if strict.
+func (l *linter) warnf(file, key, format string, args ...interface{}) {
+	if l.strict {
+		l.errorf(file, key, format, args...)
+		return
+	}
+	l.warnings++
+	fmt.Fprintf(
+		os.Stderr, "%s: %s: warning: %s\n", file, key,
+		fmt.Sprintf(format, args...))
+}
+
+// lintOvf cross-references the properties declared by the OVF descriptors
+// with the keys in the sk8 key manifest.
+func lintOvf(args []string) error {
+	fs := newFlagSet("lint.ovf")
+	manifest := fs.String(
+		"manifest", defaultManifest,
+		"The sk8 key manifest, one key per line.")
+	strict := fs.Bool(
+		"strict", false,
+		"Treat manifest keys that are not declared by a descriptor as "+
+			"errors instead of warnings.")
+	schema := fs.String(
+		"schema", "",
+		"The key schema. The keys it marks as ovfOnly must be declared "+
+			"by a descriptor and must not be in the manifest. The keys "+
+			"without a type must not be declared by a descriptor.")
+	descriptors, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(descriptors) == 0 {
+		return fmt.Errorf("invalid number of arguments for lint.ovf")
+	}
+	l := &linter{strict: *strict}
+	ovfOnly := map[string]bool{}
+	manifestOnly := map[string]bool{}
+	if *schema != "" {
+		s, err := readKeySchema(*schema)
+		if err != nil {
+			return err
+		}
+		ovfOnly = s.ovfOnlyKeys()
+		manifestOnly = s.manifestOnlyKeys()
+	}
+
+	// Read the manifest.
+	buf, err := ioutil.ReadFile(*manifest)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var manifestKeys []string
+	inManifest := map[string]bool{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if !envNameRx.MatchString(key) {
+			l.errorf(*manifest, key, "invalid key")
+		}
+		if inManifest[key] {
+			l.errorf(*manifest, key, "duplicate key")
+			continue
+		}
+		if ovfOnly[key] {
+			l.errorf(*manifest, key, "ovfOnly key in manifest")
+		}
+		inManifest[key] = true
+		manifestKeys = append(manifestKeys, key)
+	}
+
+	// Check the properties declared by each descriptor.
+	declared := map[string]bool{}
+	for _, path := range descriptors {
+		props, err := readOvfDescriptor(path)
+		if err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, p := range props {
+			key := p.fullKey
+			if seen[strings.ToUpper(key)] {
+				l.errorf(path, key, "duplicate key")
+			}
+			seen[strings.ToUpper(key)] = true
+			declared[key] = true
+			lintOvfProperty(l, path, p)
+			if !inManifest[key] && !ovfOnly[key] {
+				l.errorf(path, key, "not in manifest %s", *manifest)
+			}
+			if manifestOnly[key] {
+				l.errorf(path, key, "declared, but the schema has no type for it")
+			}
+		}
+	}
+	for key := range ovfOnly {
+		if !declared[key] {
+			l.errorf(*schema, key, "ovfOnly key not declared by an OVF descriptor")
+		}
+	}
+	for _, key := range manifestKeys {
+		if !declared[key] && !manifestOnly[key] {
+			l.warnf(*manifest, key, "not declared by an OVF descriptor")
+		}
+	}
+
+	if l.errors > 0 {
+		return fmt.Errorf("%d errors, %d warnings", l.errors, l.warnings)
+	}
+	if l.warnings > 0 {
+		fmt.Fprintf(os.Stderr, "%d warnings\n", l.warnings)
+	}
+	return nil
+}
+
+// lintOvfProperty checks the property's type, qualifiers, and default
+// value, and that a user-configurable property has a label and a
+// description.
+func lintOvfProperty(l *linter, path string, p ovfDescProperty) {
+	key := p.fullKey
+	if !supportedOvfType(p.Type) {
+		l.errorf(path, key, "unsupported type %q", p.Type)
+	}
+	for _, q := range p.qualifiers() {
+		if !q.supported() {
+			l.errorf(path, key, "unsupported qualifier %s", q.name)
+		} else if err := q.check(); err != nil {
+			l.errorf(path, key, "%v", err)
+		}
+	}
+
+	// An empty default is how a descriptor requires the user to supply a
+	// value, so only a non-empty default is checked.
+	if supportedOvfType(p.Type) && p.Default != nil && *p.Default != "" {
+		for _, e := range p.checkValue(*p.Default, false) {
+			if !strings.HasPrefix(e, "invalid qualifier") {
+				l.errorf(path, key, "default %q: %s", *p.Default, e)
+			}
+		}
+	}
+
+	if p.UserConfigurable != nil && *p.UserConfigurable {
+		if p.Label == nil || strings.TrimSpace(*p.Label) == "" {
+			l.errorf(path, key, "missing label")
+		}
+		if p.Description == nil || strings.TrimSpace(*p.Description) == "" {
+			l.errorf(path, key, "missing description")
+		}
+	}
+}
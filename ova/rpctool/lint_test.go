@@ -0,0 +1,141 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testLintSchema declares an OVF property, an ovfOnly OVF property, and
+// a key without a type that appears only in the manifest.
+const testLintSchema = `{
+  "keys": [
+    {"key": "NUM_NODES", "type": "uint16", "default": "1"},
+    {"key": "BOOTSTRAP_CLUSTER", "type": "boolean", "default": "True", "ovfOnly": true},
+    {"key": "TLS_CRT_PERM"}
+  ]
+}
+`
+
+func TestLintOvf(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	schema := filepath.Join(dir, "sk8-config-keys.json")
+	if err := ioutil.WriteFile(
+		schema, []byte(testLintSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		numNodes  = `<Property ovf:key="NUM_NODES" ovf:type="uint16" ovf:value="1"/>`
+		bootstrap = `<Property ovf:key="BOOTSTRAP_CLUSTER" ovf:type="boolean" ovf:value="True"/>`
+	)
+	testCases := []struct {
+		name       string
+		manifest   string
+		descriptor string
+		args       []string
+		wantErr    bool
+	}{
+		{
+			name:       "consistent",
+			manifest:   "NUM_NODES\nTLS_CRT_PERM\n",
+			descriptor: numNodes + bootstrap,
+			args:       []string{"-strict", "-schema", schema},
+		},
+		{
+			name:       "undeclared manifest key is a warning",
+			manifest:   "NUM_NODES\nTLS_CRT_PERM\n",
+			descriptor: numNodes,
+		},
+		{
+			name:       "undeclared manifest key with -strict",
+			manifest:   "NUM_NODES\nTLS_CRT_PERM\n",
+			descriptor: numNodes,
+			args:       []string{"-strict"},
+			wantErr:    true,
+		},
+		{
+			name:       "declared key not in manifest",
+			manifest:   "TLS_CRT_PERM\n",
+			descriptor: numNodes + bootstrap,
+			args:       []string{"-schema", schema},
+			wantErr:    true,
+		},
+		{
+			name:       "ovfOnly key in manifest",
+			manifest:   "NUM_NODES\nTLS_CRT_PERM\nBOOTSTRAP_CLUSTER\n",
+			descriptor: numNodes + bootstrap,
+			args:       []string{"-schema", schema},
+			wantErr:    true,
+		},
+		{
+			name:       "ovfOnly key not declared",
+			manifest:   "NUM_NODES\nTLS_CRT_PERM\n",
+			descriptor: numNodes,
+			args:       []string{"-schema", schema},
+			wantErr:    true,
+		},
+		{
+			name:     "key without a type declared",
+			manifest: "NUM_NODES\nTLS_CRT_PERM\n",
+			descriptor: numNodes + bootstrap +
+				`<Property ovf:key="TLS_CRT_PERM" ovf:type="string" ovf:value=""/>`,
+			args:    []string{"-schema", schema},
+			wantErr: true,
+		},
+		{
+			name:       "duplicate manifest key",
+			manifest:   "NUM_NODES\nNUM_NODES\n",
+			descriptor: numNodes,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid default",
+			manifest:   "NUM_NODES\n",
+			descriptor: `<Property ovf:key="NUM_NODES" ovf:type="uint16" ovf:value="-1"/>`,
+			wantErr:    true,
+		},
+		{
+			name:     "user-configurable without a label",
+			manifest: "NUM_NODES\n",
+			descriptor: `<Property ovf:key="NUM_NODES" ovf:type="uint16" ` +
+				`ovf:userConfigurable="true" ovf:value="1"/>`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			manifest := filepath.Join(dir, "sk8-config-keys.env")
+			if err := ioutil.WriteFile(
+				manifest, []byte(tc.manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+			descriptor := filepath.Join(dir, "product-section.ovf")
+			if err := ioutil.WriteFile(descriptor, []byte(
+				"<ProductSection>"+tc.descriptor+"</ProductSection>"),
+				0644); err != nil {
+				t.Fatal(err)
+			}
+			args := append([]string{"-manifest", manifest}, tc.args...)
+			err := lintOvf(append(args, descriptor))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -15,7 +15,6 @@
 package main
 
 import (
-	"encoding/json"
 	"encoding/xml"
 	"flag"
 	"fmt"
@@ -24,38 +23,51 @@ import (
 	"os"
 	"strings"
 
-	"github.com/vmware/vmw-guestinfo/rpcvmx"
-	"github.com/vmware/vmw-guestinfo/vmcheck"
-
 	"github.com/vmware/govmomi/ovf"
 )
 
 func main() {
-	// Check if we're running inside a VM
-	isVM, err := vmcheck.IsVirtualWorld()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to discover virtual world: %v\n", err)
-		os.Exit(1)
-	}
-	if !isVM {
-		fmt.Fprintln(os.Stderr, "must be run inside a virtual machine")
-		os.Exit(1)
-	}
-
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `usage: %s [FLAGS] COMMAND [ARGS]
 COMMANDS
-  get KEY
-    	Gets the value for the specified guestinfo key
+  get [-decrypt] [-key FILE] KEY
+    	Gets the value for the specified guestinfo key. With -decrypt a
+    	value sealed to the VM's public key is opened with the private key
+    	in FILE.
 
-  set KEY VAL
+  set [-chunked] [-chunk.size SIZE] [-encode ENCODING] KEY VAL
     	Sets the value for the specified guestinfo key. If VAL is "-" then
     	the program's standard input stream is used as the value.
 
+    	With -chunked a value larger than SIZE bytes is split across the
+    	keys KEY.chunk.0 through KEY.chunk.N-1, and KEY is set to a manifest
+    	with the number of chunks, the value's length, and its SHA-256
+    	checksum. All commands transparently reassemble and verify chunked
+    	values when they are read.
+
+    	With -encode the value is encoded with ENCODING, "base64" or
+    	"gzip+base64", and the encoding is recorded in KEY.encoding. All
+    	commands transparently decode values with a KEY.encoding, or an
+    	OVF environment property with a KEY.encoding property, when they
+    	are read.
+
   get.ovf [KEY]
     	Gets the OVF environment. If a KEY is specified then the value of the
     	OVF envionment property with the matching key will be returned.
 
+    	The OVF environment is printed in the format set by -ovf.format:
+    	"json" (default) is an object with the fields "id", "esxId",
+    	"platform", and "properties", where "properties" maps each key to
+    	its value; "yaml" has the same fields; "env" prints the properties
+    	as KEY='VAL' lines that may be sourced; and "xml" prints the OVF
+    	environment document. With -ovf.legacy the "json" and "xml" formats
+    	are the encodings of govmomi's ovf.Env.
+
+    	The OVF environment is read from the first of the -ovf.source
+    	sources that has one. The "json" and "yaml" formats report the
+    	source in the field "transport", and the "env" format in a
+    	"# transport:" comment.
+
   set.ovf [KEY] [VAL]
     	Sets the OVF environment. If VAL is "-" then the program's standard 
     	input stream is used as the value.
@@ -64,17 +76,366 @@ COMMANDS
     	the program treats the argument as the entire OVF environment payload.
     	When two arguments are provided then the OVF environment property
     	with the matching key is updated with the provided value.
+    	The property is added if no key matches, and the PropertySection is
+    	created if the OVF environment does not have one.
+
+  unset.ovf KEY...
+    	Removes the OVF environment properties with the matching keys, and
+    	their KEY.encoding properties. Keys that do not exist are ignored.
+
+  patch.ovf [-format FORMAT] [FILE]
+    	Applies the additions, updates, and removals in FILE to the OVF
+    	environment in a single write. If FILE is omitted or "-" then the
+    	program's standard input stream is used.
+
+    	FORMAT may be "json" or "env". If omitted, the format of the
+    	document is detected. A JSON patch is an object whose string values
+    	set properties and whose null values remove them. An env patch has
+    	KEY=VAL lines, which set properties, and -KEY lines, which remove
+    	them.
+
+  get-many [-source SOURCE] [-format FORMAT] [KEY...]
+    	Gets the values for the specified keys in a single session. If no
+    	KEY is specified, or KEY is "-", then the keys are read from the
+    	program's standard input stream, one per line.
+
+    	SOURCE may be "guestinfo" (default) or "ovf". FORMAT may be "json"
+    	(default) or "env".
+
+  set-many [-source SOURCE] [-format FORMAT] [KEY=VAL...]
+    	Sets the values for the specified keys in a single session. If no
+    	KEY=VAL is specified, or the argument is "-", then a document is
+    	read from the program's standard input stream.
+
+    	SOURCE may be "guestinfo" (default) or "ovf". FORMAT may be "json"
+    	or "env". If omitted, the format of the document is detected.
+
+  resolve [-namespace NS] [-default KEY=VAL]... [-defaults FILE]
+          [-explain] [-format FORMAT] KEY...
+    	Resolves the values for the specified keys using the sk8 lookup:
+    	guestinfo.NS.KEY, then the OVF environment property KEY, then KEY
+    	in the NS map of the cloud-init metadata in guestinfo.metadata, and
+    	then the defaults. Empty values and the string "null" are treated
+    	as unset. NS defaults to "sk8".
+
+    	FORMAT may be "text" (default), "json", or "env". The "text" format
+    	prints each value on its own line, in the order of the keys, and an
+    	empty line for a key that is unset. The -explain flag reports the
+    	layer that supplied each value.
+
+  export [-manifest FILE] [-format FORMAT] [-comments] [-all]
+         [-omit-secrets] [-namespace NS] [-default KEY=VAL]...
+         [-defaults FILE] [KEY...]
+    	Resolves the specified keys, followed by the keys in the manifest,
+    	and prints them in a format that is safe to source or parse. Keys
+    	that are unset are omitted unless -all is specified. With
+    	-omit-secrets the sensitive keys are omitted instead of redacted.
+
+    	FORMAT may be "sh" (default), "systemd", "json", or "yaml". The
+    	-comments flag records the source of each value.
+
+  exec [-keys KEYS] [-manifest FILE] [-prefix PREFIX] [-namespace NS]
+       [-default KEY=VAL]... [-defaults FILE] [-decrypt] [-key FILE]
+       [--] CMD [ARG...]
+    	Resolves the comma-separated KEYS, the keys in the manifest, or the
+    	keys in the manifest that begin with PREFIX, and runs CMD with the
+    	keys that are set added to its environment. PREFIX selects keys
+    	and is not removed from or added to the variable names. Keys that
+    	are unset do not change the inherited environment. With -prefix the
+    	manifest defaults to:
+
+    	  %[4]s
+
+    	The program is replaced by CMD, so the program's exit status is the
+    	exit status of CMD.
+
+  render [-in FILE] [-out FILE] [-mode MODE] [-owner OWNER]
+         [-namespace NS] [-default KEY=VAL]... [-defaults FILE] [-decrypt]
+         [-key FILE]
+    	Renders the Go text/template in FILE, or stdin if omitted, and
+    	writes the result to the -out FILE, or to stdout if omitted. The
+    	file is replaced atomically with the octal MODE (default 0644) and
+    	is owned by OWNER, a USER or USER:GROUP, if specified. The template
+    	may call the functions:
+
+    	  guestinfo KEY     the value of guestinfo.KEY
+    	  ovf KEY           the value of the OVF environment property KEY
+    	  resolve KEY       the value of KEY using the "resolve" lookup
+    	  env NAME          the value of the environment variable NAME
+    	  default DEF VAL   DEF if VAL is empty, otherwise VAL
+    	  required MSG VAL  VAL, or fails with MSG if VAL is empty
+    	  shq VAL           VAL quoted as a POSIX shell word
+    	  yamlq VAL         VAL quoted as a YAML scalar
+    	  json VAL          VAL encoded as JSON
+    	  b64enc VAL        VAL encoded with base64
+    	  b64dec VAL        VAL decoded from base64
+    	  pem VAL           the PEM blocks in VAL with their line breaks
+    	                    restored; the headers of a block, ex. of an
+    	                    encrypted key, must be on lines of their own
+    	  indent N VAL      VAL with each non-empty line indented by N
+    	                    spaces
+
+    	Values that are unset, or "null", are empty, and trailing newlines
+    	are removed.
+
+  validate [-descriptor FILE] [-strict] [-namespace NS] [-default KEY=VAL]...
+           [-defaults FILE] [-decrypt] [-key FILE] [KEY...]
+    	Resolves the properties declared by the OVF descriptor FILE, or only
+    	the specified keys, and checks each value against its property's
+    	ovf:type and the MinLen, MaxLen, MinValue, MaxValue, and ValueMap
+    	qualifiers. Every violation is reported before the program exits
+    	with a non-zero status. FILE may be a complete descriptor or a
+    	fragment with a ProductSection and defaults to
+    	/var/lib/sk8/product-section.ovf. The descriptor's default values
+    	are used for properties that are otherwise unset. Values that are
+    	still sealed are not checked. A string property that is still
+    	unset is optional, and its MinLen is checked only with -strict.
+
+  lint.ovf [-manifest FILE] [-schema SCHEMA] [-strict] DESCRIPTOR...
+    	Cross-references the properties declared by the OVF descriptors with
+    	the keys in the sk8 key manifest FILE, which defaults to
+    	/var/lib/sk8/sk8-config-keys.env. Declared keys missing from the
+    	manifest, duplicate keys, unsupported types and qualifiers, default
+    	values that violate their property's type or qualifiers, and
+    	user-configurable properties without a label or description are
+    	errors. Manifest keys that are not declared by a descriptor are
+    	warnings, or errors with -strict. The program exits with a non-zero
+    	status if there are errors. This command does not use guestinfo and
+    	may be run outside of a VM.
+
+    	With -schema the "ovfOnly" keys of the key schema SCHEMA are not
+    	expected in the manifest, and the keys without a type are not
+    	expected to be declared by a descriptor. It is an error if an
+    	"ovfOnly" key is in the manifest or is not declared by a descriptor,
+    	or if a key without a type is declared by a descriptor.
+
+  gen.ovf [-ovf FILE] [-manifest FILE] [-go FILE] SCHEMA
+    	Generates the OVF ProductSection and the sk8 key manifest from the
+    	key schema SCHEMA, ex. ova/sk8-config-keys.json. The ProductSection
+    	is written to the -ovf FILE, or to stdout if omitted, and the
+    	manifest to the -manifest FILE. The manifest omits the "ovfOnly"
+    	keys, which are read from the OVF environment by the scripts that
+    	run before sk8.sh. The -go FILE receives the Go source of the
+    	schema's sensitive keys, ex. rpctool/sensitive_schema.go. This
+    	command does not use guestinfo and may be run outside of a VM.
+
+  gen.metadata [-instance-id ID] [-format FORMAT] [-namespace NS]
+               [-default KEY=VAL]... [-defaults FILE] [-decrypt] [-key FILE]
+    	Prints cloud-init metadata for the VMware datasource rendered from
+    	the sk8 keys: "local-hostname" from HOST_FQDN, "public-keys" from
+    	SSH_PUB_KEY, and a "network" configuration that enables DHCP with
+    	the name servers NETWORK_DNS_1 and NETWORK_DNS_2 and the search
+    	domain NETWORK_DOMAIN. The "instance-id" defaults to HOST_FQDN.
+    	FORMAT may be "json" (default) or "yaml". The output may be stored
+    	with "set metadata -".
+
+  platform [-dmi DIR] [-uuid]
+    	Prints a JSON report of the hypervisor and the VM's identity: whether
+    	the CPU reports the VMware hypervisor, the version and product type
+    	reported by the backdoor, the VM's BIOS UUID, the DMI serial and
+    	product UUID in DIR (default /sys/class/dmi/id), and the OVF
+    	environment's PlatformSection. The BIOS UUID is parsed from the
+    	VMware serial, and "productUuidSwapped" reports a product UUID
+    	whose first three fields have the reverse byte order. With -uuid
+    	only the BIOS UUID is printed. This command may be run outside of
+    	a VM.
+
+  scrub [-namespace NS] [-manifest FILE] [-dry-run] [KEY...]
+    	Blanks the sensitive keys in the guestinfo namespace NS and removes
+    	them from the OVF environment. The keys considered are the KEY
+    	arguments, the keys in the manifest, the OVF environment's
+    	properties, and the keys the key schema marks as sensitive. NS
+    	defaults to "sk8".
+
+  keygen [-key FILE] [-bits BITS] [-force] [-publish KEY] [-dmi DIR]
+    	Creates the VM's RSA key pair, unless FILE already exists, and
+    	publishes the public key to guestinfo.KEY. The private key is
+    	written to FILE with mode 0600 and never leaves the VM. FILE
+    	defaults to %[2]s and KEY to %[3]s.
+
+    	The VM's BIOS UUID, read from the DMI information in DIR (default
+    	/sys/class/dmi/id), is recorded in FILE.uuid. If the UUID changes,
+    	ex. because the VM was cloned, then the key pair is replaced so
+    	that the clone does not share the key of the original VM.
+
+  seal [-pubkey FILE] [-guestinfo KEY] VAL
+    	Prints VAL sealed to a VM's public key. The public key is read from
+    	FILE, or from guestinfo.KEY if FILE is omitted. If VAL is "-" then
+    	the program's standard input stream is used as the value. A sealed
+    	value may be stored in guestinfo or the OVF environment and is
+    	opened by "get", "resolve", and "export" with -decrypt. With
+    	-pubkey this command does not use guestinfo and may be run outside
+    	of a VM.
+
+  watch [-interval DURATION] [-exec CMD] [-initial] KEY...
+    	Polls the specified guestinfo keys every DURATION (default 5s) and
+    	prints a JSON line with the key, the old and new values, and a
+    	timestamp for each change. If CMD is specified then it is run with
+    	/bin/sh for each change with the event on its standard input stream
+    	and the environment variables RPCTOOL_KEY, RPCTOOL_OLD, and
+    	RPCTOOL_NEW. With -initial the values that are set when the watch
+    	begins are also reported.
+
+  wait [-source SOURCE] [-equals VAL | -nonempty | -matches REGEX]
+       [-timeout DURATION] [-interval DURATION] KEY
+    	Waits until the value of the specified key satisfies the condition
+    	and prints the value. The default condition is -nonempty. The value
+    	is polled every -interval (default 1s). If the condition does not
+    	hold before -timeout elapses then the program exits with status 2.
+    	The default timeout of zero waits indefinitely.
+
+    	SOURCE may be "guestinfo" (default) or "ovf".
+
+  cas [-generation GEN] KEY EXPECTED NEW
+    	Sets the guestinfo key to NEW if its current value is EXPECTED and
+    	increments the generation in KEY.generation. With -generation the
+    	generation must also be GEN, where an unset generation is 0. If the
+    	current value or generation is not the expected one, or another
+    	writer modifies the key concurrently, then the program exits with
+    	status 3.
+
+  lock [-owner OWNER] [-timeout DURATION] [-interval DURATION] NAME
+    	Acquires the advisory lock NAME by setting NAME.owner to OWNER and
+    	incrementing NAME.generation, and prints OWNER. OWNER defaults to
+    	a new owner made of the BIOS UUID, the process ID, and a random
+    	token, so two callers never share it. A lock held by an explicit
+    	OWNER is acquired again. If the lock is held by another owner then
+    	it is retried every -interval until -timeout elapses, after which
+    	the program exits with status 3. The default timeout of zero does
+    	not retry.
+
+  unlock [-owner OWNER | -force] NAME
+    	Releases the advisory lock NAME if it is held by OWNER, the owner
+    	printed by lock, otherwise the program exits with status 3. With
+    	-force the lock is released regardless of its owner.
+
+  serve [-listen ADDR] [-read PATTERNS] [-write PATTERNS] [-token FILE]
+        [-allow-uid UIDS]
+    	Serves the guestinfo keys and the OVF environment as JSON over HTTP
+    	on ADDR, "unix://PATH" (default unix:///run/sk8/guestinfo.sock) or
+    	"tcp://HOST:PORT". GET /v1/keys/KEY returns guestinfo.KEY, GET
+    	/v1/ovf the OVF environment, and GET /v1/ovf/KEY the OVF property
+    	KEY. PUT to a key's path with the body {"value":"VAL"} sets it. Only
+    	the keys that match the -read (default "sk8.*,ovfEnv.*") and -write
+    	(default none) patterns may be read and written, where OVF
+    	properties are matched as ovfEnv.KEY. Sensitive values are
+    	redacted. A request is authorized if it was sent over the unix
+    	socket by one of the UIDS (default 0 and the server's user, "*"
+    	allows all) or has the header "Authorization: Bearer TOKEN" with
+    	the token in FILE. Listening on tcp requires -token.
+
+  log [-level LEVEL] [-tag TAG] MSG...
+  log -tee-host-log [-level LEVEL] [-tag TAG] [-all]
+    	Writes MSG to the VM's log file on the host, vmware.log, as
+    	"TAG: LEVEL MSG" so the progress of sk8 is visible to the owner of
+    	the host without access to the VM. LEVEL may be "debug", "info"
+    	(default), "warn", "error", or "fatal", and TAG defaults to "sk8".
+    	With -tee-host-log the program's standard input stream is copied
+    	to stdout, and the lines that begin with an sk8 log level of at
+    	least LEVEL, or every line with -all, are written to the host log
+    	as "TAG: LINE". A failure to write to the host log does not
+    	interrupt the copy. With a backend other than "vmx" the messages
+    	are written to stderr.
+
+SENSITIVE KEYS
+  The values of keys that match the -sensitive patterns are redacted when
+  the OVF environment is printed by "get.ovf" and in the output of
+  "get-many", "resolve -format json|env", "export", "watch", and
+  "serve", unless -show-secrets is specified. A single value requested
+  with "get", "get.ovf KEY", or "resolve KEY" is never redacted. The
+  chunks and encoding of a value are redacted if the value's key is.
+
+  A value of "[REDACTED]" written by "set.ovf", "patch.ovf", "set-many",
+  or "serve" keeps the stored value, so redacted output may be written
+  back without erasing the secrets. It is an error if there is no stored
+  value.
+
+HOST MODE
+  With -vm.uuid or -vm.ipath the commands read and write the guestinfo of
+  another VM via the vSphere API. The URL of the vCenter or ESXi host and
+  the credentials are read from the environment variables GOVC_URL,
+  GOVC_USERNAME, and GOVC_PASSWORD, and GOVC_INSECURE=1 skips the
+  verification of the server's certificate. The guestinfo keys are the
+  VM's extraConfig, and the OVF environment is built from, and written
+  to, the VM's vApp properties. Changes to the vApp properties are
+  received by the guest the next time the VM is powered on. The program
+  logs out of its session before it exits.
 
 FLAGS
-`, os.Args[0])
+`, os.Args[0], defaultSealKeyFile, sealPublicKey, defaultManifest)
 		flag.PrintDefaults()
 	}
+	flag.String(
+		"backend",
+		defaultBackend(),
+		"The store that holds the guestinfo keys. The backend may be set to "+
+			"\"vmx\" to use the VMX backdoor, \"file:PATH\" to use a JSON "+
+			"(*.json) or KEY=VAL file, or \"memory\" to use an in-memory "+
+			"store optionally seeded with \"memory:PATH\". The default value "+
+			"may be set with the environment variable RPCTOOL_BACKEND.")
+	flag.String(
+		"vm.uuid",
+		"",
+		"The BIOS UUID of a VM whose guestinfo is read and written via the "+
+			"vSphere API instead of the backend. Please see HOST MODE.")
+	flag.String(
+		"vm.ipath",
+		"",
+		"The inventory path of a VM whose guestinfo is read and written "+
+			"via the vSphere API instead of the backend. Please see "+
+			"HOST MODE.")
+	flag.Var(
+		&sensitive,
+		"sensitive",
+		"A comma-separated list of case-insensitive patterns that match the "+
+			"keys whose values are redacted by \"get.ovf\", \"get-many\", "+
+			"\"resolve\", \"export\", \"watch\", and \"serve\", and "+
+			"blanked by \"scrub\". The default value may be set with the "+
+			"environment variable RPCTOOL_SENSITIVE_KEYS.")
+	flag.BoolVar(
+		&showSecrets,
+		"show-secrets",
+		false,
+		"Print the values of sensitive keys instead of redacting them.")
 	flag.String(
 		"ovf.format",
 		"json",
 		"The format of the OVF environment payload when returned by "+
 			"\"get.ovf\" or set via \"set.ovf\". The format string may be "+
-			" set to \"xml\" or \"json\".")
+			"set to \"json\", \"yaml\", \"env\", or \"xml\". The "+
+			"\"yaml\" and \"env\" formats may only be returned by "+
+			"\"get.ovf\".")
+	flag.Var(
+		&ovfEnvSources,
+		"ovf.source",
+		"A comma-separated list of the sources from which the OVF "+
+			"environment is read, in the order they are tried. A source "+
+			"may be \"guestinfo\", \"iso\" to read ovf-env.xml from the "+
+			"ISO transport's CD-ROM mounted at /media/cdrom, "+
+			"/media/cdrom0, /mnt/cdrom, or /mnt/cdrom0, \"iso:DIR\" to "+
+			"read it from the CD-ROM mounted at DIR, or \"file:PATH\". "+
+			"Changes to the OVF environment are always written to "+
+			"guestinfo, which is read instead of an ISO or file until "+
+			"the ISO or file changes. The default value may be set with "+
+			"the environment variable RPCTOOL_OVF_SOURCE.")
+	flag.Bool(
+		"ovf.legacy",
+		false,
+		"Use the legacy \"json\" and \"xml\" OVF environment payloads, "+
+			"which are the encodings of govmomi's ovf.Env, with "+
+			"\"get.ovf\" and \"set.ovf\".")
+	if v := os.Getenv("RPCTOOL_SENSITIVE_KEYS"); v != "" {
+		if err := sensitive.Set(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid RPCTOOL_SENSITIVE_KEYS: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("RPCTOOL_OVF_SOURCE"); v != "" {
+		if err := ovfEnvSources.Set(v); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid RPCTOOL_OVF_SOURCE: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -84,108 +445,151 @@ FLAGS
 	}
 
 	// Validate the command name.
-	cmdName := flag.Arg(0)
-	if strings.EqualFold(cmdName, "get") {
-		cmdName = "get"
-	} else if strings.EqualFold(cmdName, "set") {
-		cmdName = "set"
-	} else if strings.EqualFold(cmdName, "get.ovf") {
-		cmdName = "get.ovf"
-	} else if strings.EqualFold(cmdName, "set.ovf") {
-		cmdName = "set.ovf"
-	} else {
-		fmt.Fprintf(os.Stderr, "invalid command: %s\n", cmdName)
+	cmdName := strings.ToLower(flag.Arg(0))
+	switch cmdName {
+	case "get", "set", "get.ovf", "set.ovf", "unset.ovf", "patch.ovf",
+		"get-many", "set-many", "resolve", "export", "exec", "render",
+		"validate", "lint.ovf", "gen.ovf", "gen.metadata", "platform",
+		"scrub", "keygen", "seal", "watch", "wait", "cas", "lock", "unlock",
+		"serve", "log":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid command: %s\n", flag.Arg(0))
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	// Validate the OVF format.
-	ovfFormat := flag.Lookup("ovf.format").Value.String()
-	if strings.EqualFold(ovfFormat, "json") {
-		ovfFormat = "json"
-	} else if strings.EqualFold(ovfFormat, "xml") {
-		ovfFormat = "xml"
-	} else {
-		fmt.Fprintf(os.Stderr, "invalid ovf.format: %s\n", ovfFormat)
+	ovfFormat, err := parseChoice(
+		"ovf.format", flag.Lookup("ovf.format").Value.String(),
+		"json", "yaml", "env", "xml")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		flag.Usage()
 		os.Exit(1)
 	}
+	ovfLegacy := flag.Lookup("ovf.legacy").Value.String() == "true"
+
+	// Commands that do not use guestinfo are run before the backend is
+	// created so they may be run outside of a VM.
+	switch cmdName {
+	case "lint.ovf":
+		exitOnError(lintOvf(flag.Args()[1:]))
+		return
+	case "gen.ovf":
+		exitOnError(generateOvf(flag.Args()[1:]))
+		return
+	case "platform":
+		exitOnError(platform(
+			flag.Lookup("backend").Value.String(), flag.Args()[1:]))
+		return
+	case "seal":
+		if !sealReadsGuestinfo(flag.Args()[1:]) {
+			exitOnError(seal(nil, flag.Args()[1:]))
+			return
+		}
+	}
 
-	// Get the VMX config.
-	config := rpcvmx.NewConfig()
+	// Get the guestinfo backend. In host mode the OVF environment is only
+	// read from the target VM.
+	var backend Backend
+	vmUUID := flag.Lookup("vm.uuid").Value.String()
+	vmIpath := flag.Lookup("vm.ipath").Value.String()
+	if vmUUID != "" || vmIpath != "" {
+		backend, err = newVsphereBackend(vmUUID, vmIpath)
+		ovfEnvSources = ovfSources{{kind: "guestinfo"}}
+	} else {
+		backend, err = newBackend(flag.Lookup("backend").Value.String())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if c, ok := backend.(io.Closer); ok {
+		exitHooks = append(exitHooks, func() { c.Close() })
+		defer runExitHooks()
+	}
+	config := &valueBackend{Backend: backend}
 
 	// Figure out which operation to perform.
 	switch cmdName {
 	case "get":
-		if flag.NArg() < 2 {
+		fs := newFlagSet(cmdName)
+		df := addDecryptFlags(fs)
+		args, err := parseLeadingFlags(fs, flag.Args()[1:])
+		exitOnError(err)
+		if len(args) < 1 {
 			fmt.Fprintf(
 				os.Stderr,
 				"invalid number of arguments for %s\n",
 				cmdName)
 			flag.Usage()
-			os.Exit(1)
+			exit(1)
 		}
-		key := "guestinfo." + flag.Arg(1)
+		df.apply(config)
+		key := "guestinfo." + args[0]
 		val, err := config.String(key, "")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to get %s: %v\n", key, err)
-			os.Exit(1)
+			exit(1)
 		}
 		if val != "" {
 			fmt.Println(val)
 		}
 	case "set":
-		if flag.NArg() < 3 {
+		fs := newFlagSet(cmdName)
+		chunked := fs.Bool(
+			"chunked", false,
+			"Split values larger than -chunk.size across several keys.")
+		chunkSize := fs.Int(
+			"chunk.size", defaultChunkSize,
+			"The maximum size, in bytes, of a chunk.")
+		encoding := fs.String(
+			"encode", "",
+			"Encode the value with \"base64\" or \"gzip+base64\" and "+
+				"record the encoding in KEY.encoding.")
+		args, err := parseLeadingFlags(fs, flag.Args()[1:])
+		exitOnError(err)
+		if len(args) < 2 {
 			fmt.Fprintf(
 				os.Stderr,
 				"invalid number of arguments for %s\n",
 				cmdName)
 			flag.Usage()
-			os.Exit(1)
+			exit(1)
+		}
+		if *chunked {
+			if *chunkSize <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid chunk.size: %d\n", *chunkSize)
+				exit(1)
+			}
+			config.chunkSize = *chunkSize
 		}
-		key := "guestinfo." + flag.Arg(1)
-		val := flag.Arg(2)
+		if config.encoding, err = parseEncoding(*encoding); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		key := "guestinfo." + args[0]
+		val := args[1]
 		if val == "-" {
 			stdin, err := readStdin()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+				exit(1)
 			}
 			val = stdin
 		}
 		if err := config.SetString(key, val); err != nil {
 			fmt.Fprintf(os.Stderr, "failed to set %s: %v\n", key, err)
-			os.Exit(1)
+			exit(1)
 		}
 	case "get.ovf":
 		switch flag.NArg() {
 		case 1:
 			// Print the entire OVF environment payload.
-			ovfEnv, err := getOvfEnv(config)
+			err := writeOvfEnv(os.Stdout, ovfFormat, ovfLegacy, config)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
-			}
-			var enc encoder
-			switch ovfFormat {
-			case "json":
-				jsonEnc := json.NewEncoder(os.Stdout)
-				jsonEnc.SetIndent("", "  ")
-				enc = jsonEnc
-			case "xml":
-				xmlEnc := xml.NewEncoder(os.Stdout)
-				xmlEnc.Indent("", "  ")
-				enc = xmlEnc
-			default:
-				fmt.Fprintf(os.Stderr, "invalid ovf.format: %s\n", ovfFormat)
-				os.Exit(1)
-			}
-			if err := enc.Encode(ovfEnv); err != nil {
-				fmt.Fprintf(
-					os.Stderr,
-					"failed to encode OVF environment: %v\n",
-					err)
-				os.Exit(1)
+				exit(1)
 			}
 		case 2:
 			// Print the OVF property that matches the provided KEY
@@ -193,7 +597,7 @@ FLAGS
 			val, err := getValueInOvfEnv(key, config)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed to get %s: %v\n", key, err)
-				os.Exit(1)
+				exit(1)
 			}
 			if val != "" {
 				fmt.Println(val)
@@ -204,7 +608,7 @@ FLAGS
 				"invalid number of arguments for %s\n",
 				cmdName)
 			flag.Usage()
-			os.Exit(1)
+			exit(1)
 		}
 	case "set.ovf":
 		switch flag.NArg() {
@@ -217,31 +621,20 @@ FLAGS
 			} else {
 				rdr = strings.NewReader(val)
 			}
-			var dec decoder
-			switch ovfFormat {
-			case "json":
-				dec = json.NewDecoder(rdr)
-			case "xml":
-				dec = xml.NewDecoder(rdr)
-			default:
-				fmt.Fprintf(os.Stderr, "invalid ovf.format: %s\n", ovfFormat)
-				os.Exit(1)
+			val, err = readOvfEnv(rdr, ovfFormat, ovfLegacy)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
 			}
-
-			var ovfEnv ovf.Env
-			if err := dec.Decode(&ovfEnv); err != nil {
-				fmt.Fprintf(
-					os.Stderr,
-					"failed to decode OVF environment as %s: %v",
-					ovfFormat, err)
-				os.Exit(1)
+			if val, err = restoreRedactedOvfEnv(config, val); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exit(1)
 			}
 
 			key := "guestinfo.ovfEnv"
-			val = ovfEnv.MarshalManual()
 			if err := config.SetString(key, val); err != nil {
 				fmt.Fprintf(os.Stderr, "failed to set %s: %v\n", key, err)
-				os.Exit(1)
+				exit(1)
 			}
 		case 3:
 			// Sets a property in the OVF environment
@@ -251,13 +644,13 @@ FLAGS
 				stdin, err := readStdin()
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
-					os.Exit(1)
+					exit(1)
 				}
 				val = stdin
 			}
 			if err := setValueInOvfEnv(key, val, config); err != nil {
 				fmt.Fprintf(os.Stderr, "failed to set %s: %v\n", key, err)
-				os.Exit(1)
+				exit(1)
 			}
 		default:
 			fmt.Fprintf(
@@ -265,9 +658,58 @@ FLAGS
 				"invalid number of arguments for %s\n",
 				cmdName)
 			flag.Usage()
-			os.Exit(1)
+			exit(1)
 		}
+	case "unset.ovf":
+		exitOnError(unsetOvf(config, flag.Args()[1:]))
+	case "patch.ovf":
+		exitOnError(patchOvf(config, flag.Args()[1:]))
+	case "get-many":
+		exitOnError(getMany(config, flag.Args()[1:]))
+	case "set-many":
+		exitOnError(setMany(config, flag.Args()[1:]))
+	case "resolve":
+		exitOnError(resolve(config, flag.Args()[1:]))
+	case "export":
+		exitOnError(exportConfig(config, flag.Args()[1:]))
+	case "exec":
+		exitOnError(execWithConfig(config, flag.Args()[1:]))
+	case "render":
+		exitOnError(render(config, flag.Args()[1:]))
+	case "validate":
+		exitOnError(validate(config, flag.Args()[1:]))
+	case "gen.metadata":
+		exitOnError(genMetadata(config, flag.Args()[1:]))
+	case "scrub":
+		exitOnError(scrub(config, flag.Args()[1:]))
+	case "keygen":
+		exitOnError(keygen(config, flag.Args()[1:]))
+	case "seal":
+		exitOnError(seal(config, flag.Args()[1:]))
+	case "watch":
+		exitOnError(watch(config, flag.Args()[1:]))
+	case "wait":
+		exitOnError(waitFor(config, flag.Args()[1:]))
+	case "cas":
+		exitOnError(cas(config, flag.Args()[1:]))
+	case "lock":
+		exitOnError(lock(config, flag.Args()[1:]))
+	case "unlock":
+		exitOnError(unlock(config, flag.Args()[1:]))
+	case "serve":
+		exitOnError(serve(config, flag.Args()[1:]))
+	case "log":
+		exitOnError(logToHost(config, flag.Args()[1:]))
+	}
+}
+
+// defaultBackend returns the value of the environment variable
+// RPCTOOL_BACKEND or "vmx" if the variable is not set.
+func defaultBackend() string {
+	if v := os.Getenv("RPCTOOL_BACKEND"); v != "" {
+		return v
 	}
+	return "vmx"
 }
 
 type encoder interface {
@@ -286,79 +728,119 @@ func readStdin() (string, error) {
 	return string(buf), nil
 }
 
-func getOvfEnv(config *rpcvmx.Config) (*ovf.Env, error) {
-	ovfEnvSz, err := config.String("guestinfo.ovfEnv", "")
+func getOvfEnv(config Backend) (*ovf.Env, error) {
+	ovfEnv, _, err := getOvfEnvSource(config)
+	return ovfEnv, err
+}
+
+// getOvfEnvSource is like getOvfEnv but also returns the source from which
+// the OVF environment was read.
+func getOvfEnvSource(config Backend) (*ovf.Env, ovfSource, error) {
+	ovfEnvSz, src, err := readOvfEnvSource(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get guestinfo.ovfEnv: %v", err)
+		return nil, src, err
+	}
+	if ovfEnvSz == "" {
+		return nil, src, fmt.Errorf(
+			"OVF environment not found in %s", ovfEnvSources.String())
 	}
 
 	var ovfEnv ovf.Env
 	if err := xml.Unmarshal([]byte(ovfEnvSz), &ovfEnv); err != nil {
-		return nil, fmt.Errorf("failed to unmarshall guestinfo.ovfEnv: %v", err)
+		return nil, src, fmt.Errorf(
+			"failed to unmarshall OVF environment from %s: %v", src, err)
 	}
 
+	return &ovfEnv, src, nil
+}
+
+// getOvfEnvIfSet is like getOvfEnv but returns an empty OVF environment
+// if none of the sources has one, ex. when the VM was not deployed from
+// an OVF with properties.
+func getOvfEnvIfSet(config Backend) (*ovf.Env, error) {
+	ovfEnvSz, src, err := readOvfEnvSource(config)
+	if err != nil {
+		return nil, err
+	}
+	if ovfEnvSz == "" {
+		return &ovf.Env{}, nil
+	}
+	var ovfEnv ovf.Env
+	if err := xml.Unmarshal([]byte(ovfEnvSz), &ovfEnv); err != nil {
+		return nil, fmt.Errorf(
+			"failed to unmarshall OVF environment from %s: %v", src, err)
+	}
 	return &ovfEnv, nil
 }
 
-func getValueInOvfEnv(key string, config *rpcvmx.Config) (string, error) {
+func getValueInOvfEnv(key string, config Backend) (string, error) {
 
 	ovfEnv, err := getOvfEnv(config)
 	if err != nil {
 		return "", err
 	}
 
-	if ovfEnv.Property == nil {
+	return getDecodedOvfEnvProperty(ovfEnv, key)
+}
+
+// getDecodedOvfEnvProperty returns the value of the OVF environment
+// property with the matching key, decoded with the encoding recorded in
+// the property KEY.encoding, if any.
+func getDecodedOvfEnvProperty(ovfEnv *ovf.Env, key string) (string, error) {
+	val := getOvfEnvProperty(ovfEnv, key)
+	if val == "" {
 		return "", nil
 	}
+	enc := getOvfEnvProperty(ovfEnv, key+encodingSuffix)
+	val, err := decodeValue(val, enc)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return val, nil
+}
+
+// getOvfEnvProperty returns the value of the OVF environment property
+// with the matching key.
+func getOvfEnvProperty(ovfEnv *ovf.Env, key string) string {
+	if ovfEnv.Property == nil {
+		return ""
+	}
 
 	for _, prop := range ovfEnv.Property.Properties {
 		if strings.EqualFold(prop.Key, key) {
-			return prop.Value, nil
+			return prop.Value
 		}
 	}
 
-	return "", nil
+	return ""
 }
 
-func setValueInOvfEnv(key, val string, config *rpcvmx.Config) error {
+func setValueInOvfEnv(key, val string, config Backend) error {
+	return setValuesInOvfEnv(
+		[]string{key}, map[string]string{key: val}, config)
+}
 
-	ovfEnv, err := getOvfEnv(config)
+func setValuesInOvfEnv(
+	keys []string, vals map[string]string, config Backend) error {
+
+	doc, err := getOvfEnvDoc(config)
 	if err != nil {
 		return err
 	}
 
-	if ovfEnv.Property == nil {
-		return nil
-	}
-
-	var props []ovf.EnvProperty
-
-	// Find the property with the matching key name and update its value.
-	keyFound := false
-	for _, p := range ovfEnv.Property.Properties {
-		if strings.EqualFold(p.Key, key) {
-			props = append(props, ovf.EnvProperty{
-				Key:   p.Key,
-				Value: val,
-			})
-			keyFound = true
-		} else {
-			props = append(props, p)
+	// The redacted value placeholder keeps the stored value.
+	for _, key := range keys {
+		if vals[key] == redactedValue {
+			if _, ok := doc.get(key); !ok {
+				return errRedactedValue(key)
+			}
+			continue
 		}
+		doc.set(key, vals[key])
 	}
 
-	// If the key was not found then add a new property with it and the value.
-	if !keyFound {
-		props = append(props, ovf.EnvProperty{
-			Key:   key,
-			Value: val,
-		})
-	}
-
-	ovfEnv.Property.Properties = props
-
-	// Go ahead and update the OVF environment in the guestinfo
-	// since all of the other properties in the OVF environment will
-	// remain the same.
-	return config.SetString("guestinfo.ovfEnv", ovfEnv.MarshalManual())
+	// Only the modified properties are rewritten. The rest of the OVF
+	// environment is written back exactly as it was read. A missing
+	// PropertySection is created.
+	return setOvfEnvDoc(config, doc)
 }
@@ -0,0 +1,275 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// metadataKey is the guestinfo key from which cloud-init's VMware
+	// datasource reads the instance metadata. Like any guestinfo key, it
+	// is decoded with the encoding in guestinfo.metadata.encoding.
+	metadataKey = "guestinfo.metadata"
+
+	// sourceMetadata is the source of a value read from the metadata.
+	sourceMetadata = metadataKey
+)
+
+// parseMetadata parses cloud-init metadata and returns the string values
+// in the map named ns, or the top-level values if ns is empty. The
+// metadata may be JSON or YAML. Values that are not strings are
+// returned as their JSON encoding, ex. 3, true, or ["a","b"].
+func parseMetadata(doc, ns string) (map[string]string, error) {
+	var m map[string]interface{}
+	if strings.HasPrefix(strings.TrimSpace(doc), "{") {
+		// JSON is also YAML, but the decoder keeps the text of numbers.
+		dec := json.NewDecoder(strings.NewReader(doc))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+	} else {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(doc), &v); err != nil {
+			return nil, err
+		}
+		if v = yamlToJSON(v); v != nil {
+			var ok bool
+			if m, ok = v.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("metadata is not a mapping")
+			}
+		}
+	}
+
+	if ns != "" {
+		v, ok := m[ns]
+		if !ok || v == nil {
+			return map[string]string{}, nil
+		}
+		if m, ok = v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("metadata %s is not a mapping", ns)
+		}
+	}
+	vals := map[string]string{}
+	for k, v := range m {
+		switch tv := v.(type) {
+		case nil:
+			vals[k] = ""
+		case string:
+			vals[k] = tv
+		default:
+			buf, err := json.Marshal(tv)
+			if err != nil {
+				return nil, err
+			}
+			vals[k] = string(buf)
+		}
+	}
+	return vals, nil
+}
+
+// yamlToJSON returns the value decoded by the YAML library with its
+// mappings converted to map[string]interface{}, so it may be encoded as
+// JSON. The keys of a mapping are converted to strings.
+func yamlToJSON(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for k, v := range tv {
+			m[fmt.Sprint(k)] = yamlToJSON(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range tv {
+			tv[i] = yamlToJSON(v)
+		}
+	}
+	return v
+}
+
+// cloudInitMetadata is the instance metadata read by cloud-init's VMware
+// datasource from guestinfo.metadata.
+type cloudInitMetadata struct {
+	InstanceID    string            `json:"instance-id"`
+	LocalHostname string            `json:"local-hostname,omitempty"`
+	PublicKeys    []string          `json:"public-keys,omitempty"`
+	Network       *cloudInitNetwork `json:"network,omitempty"`
+}
+
+// cloudInitNetwork is a version 2 network configuration that enables DHCP
+// on the VM's Ethernet interfaces with the sk8 name servers and search
+// domain.
+type cloudInitNetwork struct {
+	Version   int                              `json:"version"`
+	Ethernets map[string]cloudInitEthernetConf `json:"ethernets"`
+}
+
+type cloudInitEthernetConf struct {
+	Match       map[string]string        `json:"match"`
+	DHCP4       bool                     `json:"dhcp4"`
+	Nameservers *cloudInitNameserverConf `json:"nameservers,omitempty"`
+}
+
+type cloudInitNameserverConf struct {
+	Addresses []string `json:"addresses,omitempty"`
+	Search    []string `json:"search,omitempty"`
+}
+
+// genMetadata prints cloud-init metadata rendered from the sk8 keys.
+func genMetadata(config Backend, args []string) error {
+	fs := newFlagSet("gen.metadata")
+	instanceID := fs.String(
+		"instance-id", "",
+		"The instance ID. Defaults to the value of HOST_FQDN.")
+	format := fs.String(
+		"format", "json",
+		"The format of the output: \"json\" or \"yaml\".")
+	rf := addResolverFlags(fs)
+	df := addDecryptFlags(fs)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments for gen.metadata")
+	}
+	df.apply(config)
+	if *format, err = parseChoice(
+		"format", *format, "json", "yaml"); err != nil {
+		return err
+	}
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{
+		"HOST_FQDN", "SSH_PUB_KEY",
+		"NETWORK_DOMAIN", "NETWORK_DNS_1", "NETWORK_DNS_2",
+	}
+	vals := map[string]string{}
+	if err := batch(config, func() error {
+		for _, k := range keys {
+			rv, err := r.resolve(k)
+			if err != nil {
+				return err
+			}
+			vals[k] = strings.TrimSpace(rv.Value)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	md := cloudInitMetadata{
+		InstanceID:    *instanceID,
+		LocalHostname: vals["HOST_FQDN"],
+	}
+	if md.InstanceID == "" {
+		md.InstanceID = md.LocalHostname
+	}
+	if md.InstanceID == "" {
+		return fmt.Errorf("instance-id is required if HOST_FQDN is unset")
+	}
+	for _, l := range strings.Split(vals["SSH_PUB_KEY"], "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			md.PublicKeys = append(md.PublicKeys, l)
+		}
+	}
+	var ns cloudInitNameserverConf
+	for _, k := range []string{"NETWORK_DNS_1", "NETWORK_DNS_2"} {
+		if v := vals[k]; !isUnset(v) {
+			ns.Addresses = append(ns.Addresses, v)
+		}
+	}
+	if v := vals["NETWORK_DOMAIN"]; !isUnset(v) {
+		ns.Search = []string{v}
+	}
+	if len(ns.Addresses) > 0 || len(ns.Search) > 0 {
+		md.Network = &cloudInitNetwork{
+			Version: 2,
+			Ethernets: map[string]cloudInitEthernetConf{
+				"nics": {
+					Match:       map[string]string{"name": "e*"},
+					DHCP4:       true,
+					Nameservers: &ns,
+				},
+			},
+		}
+	}
+
+	var buf bytes.Buffer
+	switch *format {
+	case "json":
+		jv, err := json.MarshalIndent(md, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %v", err)
+		}
+		buf.Write(jv)
+		buf.WriteByte('\n')
+	case "yaml":
+		writeMetadataYAML(&buf, md)
+	}
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// writeMetadataYAML writes the metadata as YAML.
+func writeMetadataYAML(buf *bytes.Buffer, md cloudInitMetadata) {
+	fmt.Fprintf(buf, "instance-id: %s\n", yamlQuote(md.InstanceID))
+	if md.LocalHostname != "" {
+		fmt.Fprintf(buf, "local-hostname: %s\n", yamlQuote(md.LocalHostname))
+	}
+	if len(md.PublicKeys) > 0 {
+		buf.WriteString("public-keys:\n")
+		for _, k := range md.PublicKeys {
+			fmt.Fprintf(buf, "  - %s\n", yamlQuote(k))
+		}
+	}
+	if md.Network == nil {
+		return
+	}
+	fmt.Fprintf(buf, "network:\n  version: %d\n  ethernets:\n",
+		md.Network.Version)
+	for name, e := range md.Network.Ethernets {
+		fmt.Fprintf(buf, "    %s:\n      match:\n", yamlKey(name))
+		for k, v := range e.Match {
+			fmt.Fprintf(buf, "        %s: %s\n", yamlKey(k), yamlQuote(v))
+		}
+		fmt.Fprintf(buf, "      dhcp4: %t\n", e.DHCP4)
+		if ns := e.Nameservers; ns != nil {
+			buf.WriteString("      nameservers:\n")
+			for _, l := range []struct {
+				name string
+				vals []string
+			}{{"addresses", ns.Addresses}, {"search", ns.Search}} {
+				if len(l.vals) == 0 {
+					continue
+				}
+				fmt.Fprintf(buf, "        %s:\n", l.name)
+				for _, v := range l.vals {
+					fmt.Fprintf(buf, "          - %s\n", yamlQuote(v))
+				}
+			}
+		}
+	}
+}
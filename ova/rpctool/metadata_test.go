@@ -0,0 +1,128 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	testCases := []struct {
+		name    string
+		doc     string
+		ns      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "json",
+			doc:  `{"sk8": {"A": "x", "N": 2.50, "B": true, "L": ["a"], "Z": null}}`,
+			ns:   "sk8",
+			want: map[string]string{
+				"A": "x", "N": "2.50", "B": "true", "L": `["a"]`, "Z": ""},
+		},
+		{
+			name: "json top level",
+			doc:  `{"instance-id": "i-1"}`,
+			want: map[string]string{"instance-id": "i-1"},
+		},
+		{
+			name: "quoted scalar with a comment",
+			doc:  "A: \"x\" # c\nB: 'it''s'\n",
+			want: map[string]string{"A": "x", "B": "it's"},
+		},
+		{
+			name: "literal block with strip chomping",
+			doc:  "A: |-\n  a\n  b\n",
+			want: map[string]string{"A": "a\nb"},
+		},
+		{
+			name: "literal block",
+			doc:  "A: |\n  a\n  b\n",
+			want: map[string]string{"A": "a\nb\n"},
+		},
+		{
+			name: "folded block",
+			doc:  "A: >\n  a\n  b\n\n  c\n",
+			want: map[string]string{"A": "a b\nc\n"},
+		},
+		{
+			name: "multi-line plain scalar",
+			doc:  "A: a\n  b\n",
+			want: map[string]string{"A": "a b"},
+		},
+		{
+			name: "namespace",
+			doc: "instance-id: i-1\nsk8:\n  NUM_NODES: 2\n" +
+				"  NODE_TYPE: both\n  NAMES: [a, b]\n  EMPTY:\n",
+			ns: "sk8",
+			want: map[string]string{
+				"NUM_NODES": "2", "NODE_TYPE": "both",
+				"NAMES": `["a","b"]`, "EMPTY": ""},
+		},
+		{
+			name: "nested mapping as json",
+			doc:  "sk8:\n  M:\n    a: 1\n",
+			ns:   "sk8",
+			want: map[string]string{"M": `{"a":1}`},
+		},
+		{
+			name: "missing namespace",
+			doc:  "instance-id: i-1\n",
+			ns:   "sk8",
+			want: map[string]string{},
+		},
+		{
+			name: "document start and comments",
+			doc:  "---\n# metadata\nA: x\n",
+			want: map[string]string{"A": "x"},
+		},
+		{
+			name:    "tab indentation",
+			doc:     "sk8:\n\tA: x\n",
+			ns:      "sk8",
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			doc:     `{"sk8": }`,
+			wantErr: true,
+		},
+		{
+			name:    "not a mapping",
+			doc:     "- a\n- b\n",
+			wantErr: true,
+		},
+		{
+			name:    "namespace not a mapping",
+			doc:     "sk8: x\n",
+			ns:      "sk8",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vals, err := parseMetadata(tc.doc, tc.ns)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && !reflect.DeepEqual(vals, tc.want) {
+				t.Errorf("parseMetadata(%q, %q) = %q, want %q",
+					tc.doc, tc.ns, vals, tc.want)
+			}
+		})
+	}
+}
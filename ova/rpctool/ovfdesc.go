@@ -0,0 +1,280 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+const (
+	// ovfNS is the namespace of an OVF descriptor.
+	ovfNS = "http://schemas.dmtf.org/ovf/envelope/1"
+)
+
+// ovfQualifierRx matches a qualifier in a property's ovf:qualifiers
+// attribute, ex. MinLen(1) or ValueMap{"a", "b"}.
+var ovfQualifierRx = regexp.MustCompile(`(\w+)\s*(?:\(([^)]*)\)|\{([^}]*)\})`)
+
+// ovfDescProperty is a property declared by a ProductSection in an OVF
+// descriptor.
+type ovfDescProperty struct {
+	ovf.Property
+
+	// fullKey is the key of the property in the OVF environment. It is
+	// the property's key qualified by the class and instance of its
+	// ProductSection, if any.
+	fullKey string
+}
+
+// readOvfDescriptor reads the OVF descriptor at path and returns the
+// properties declared by its ProductSections. The descriptor may be a
+// complete OVF envelope or a fragment with one or more ProductSections,
+// such as ova/ovf/product-section.ovf.
+func readOvfDescriptor(path string) ([]ovfDescProperty, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor: %v", err)
+	}
+	root, err := xmlRootName(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if root != "Envelope" {
+		buf = wrapOvfFragment(buf)
+	}
+	env, err := ovf.Unmarshal(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	var sections []ovf.ProductSection
+	if env.Product != nil {
+		sections = append(sections, *env.Product)
+	}
+	if env.VirtualSystem != nil {
+		sections = append(sections, env.VirtualSystem.Product...)
+	}
+	var props []ovfDescProperty
+	for _, s := range sections {
+		for _, p := range s.Property {
+			key := p.Key
+			if s.Class != nil && *s.Class != "" {
+				key = *s.Class + "." + key
+			}
+			if s.Instance != nil && *s.Instance != "" {
+				key = key + "." + *s.Instance
+			}
+			props = append(props, ovfDescProperty{Property: p, fullKey: key})
+		}
+	}
+	return props, nil
+}
+
+// xmlRootName returns the local name of the document's root element.
+func xmlRootName(buf []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(buf))
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return "", fmt.Errorf("no root element")
+		}
+		if err != nil {
+			return "", err
+		}
+		if t, ok := tok.(xml.StartElement); ok {
+			return t.Name.Local, nil
+		}
+	}
+}
+
+// wrapOvfFragment returns the fragment inside an envelope and virtual
+// system, the same way the Makefile assembles sk8-*.ovf.
+func wrapOvfFragment(buf []byte) []byte {
+	var w bytes.Buffer
+	fmt.Fprintf(&w, `<Envelope xmlns="%[1]s" xmlns:ovf="%[1]s">`, ovfNS)
+	w.WriteString(`<VirtualSystem ovf:id="fragment">`)
+	w.Write(buf)
+	w.WriteString(`</VirtualSystem></Envelope>`)
+	return w.Bytes()
+}
+
+// ovfQualifier is a qualifier in a property's ovf:qualifiers attribute.
+type ovfQualifier struct {
+	name string
+	args []string
+}
+
+// qualifiers returns the property's qualifiers in the order they are
+// declared. The arguments of a ValueMap are unquoted.
+func (p ovfDescProperty) qualifiers() []ovfQualifier {
+	if p.Qualifiers == nil {
+		return nil
+	}
+	var quals []ovfQualifier
+	for _, m := range ovfQualifierRx.FindAllStringSubmatch(*p.Qualifiers, -1) {
+		args := m[2]
+		if m[3] != "" {
+			args = m[3]
+		}
+		q := ovfQualifier{name: m[1]}
+		for _, a := range strings.Split(args, ",") {
+			a = strings.TrimSpace(a)
+			if uq, err := strconv.Unquote(a); err == nil {
+				a = uq
+			}
+			q.args = append(q.args, a)
+		}
+		quals = append(quals, q)
+	}
+	return quals
+}
+
+// checkValue returns the ways in which the value violates the property's
+// type and qualifiers. The MinLen qualifier is not checked if skipMinLen
+// is true.
+func (p ovfDescProperty) checkValue(val string, skipMinLen bool) []string {
+	var errs []string
+	if err := checkOvfType(p.Type, val); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, q := range p.qualifiers() {
+		if err := q.check(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		switch q.name {
+		case "MinLen", "MaxLen":
+			if q.name == "MinLen" && skipMinLen {
+				continue
+			}
+			// The length of an OVF string is its number of characters.
+			n, _ := strconv.Atoi(q.args[0])
+			l := utf8.RuneCountInString(val)
+			if q.name == "MinLen" && l < n {
+				errs = append(errs, fmt.Sprintf(
+					"length %d is less than %d", l, n))
+			}
+			if q.name == "MaxLen" && l > n {
+				errs = append(errs, fmt.Sprintf(
+					"length %d is greater than %d", l, n))
+			}
+		case "MinValue", "MaxValue":
+			// An invalid number is reported by the type check.
+			n, _ := strconv.ParseFloat(q.args[0], 64)
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				continue
+			}
+			if q.name == "MinValue" && v < n {
+				errs = append(errs, fmt.Sprintf(
+					"value is less than %s", q.args[0]))
+			}
+			if q.name == "MaxValue" && v > n {
+				errs = append(errs, fmt.Sprintf(
+					"value is greater than %s", q.args[0]))
+			}
+		case "ValueMap":
+			found := false
+			for _, a := range q.args {
+				if a == val {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf(
+					"value is not one of %s", strings.Join(q.args, ", ")))
+			}
+		}
+	}
+	return errs
+}
+
+// supported returns true if the qualifier is one checkValue enforces.
+func (q ovfQualifier) supported() bool {
+	switch q.name {
+	case "MinLen", "MaxLen", "MinValue", "MaxValue", "ValueMap":
+		return true
+	}
+	return false
+}
+
+// check returns an error if the arguments of a supported qualifier are
+// invalid.
+func (q ovfQualifier) check() error {
+	var err error
+	switch q.name {
+	case "MinLen", "MaxLen":
+		_, err = strconv.Atoi(q.args[0])
+	case "MinValue", "MaxValue":
+		_, err = strconv.ParseFloat(q.args[0], 64)
+	}
+	if err != nil || (q.supported() && q.name != "ValueMap" && len(q.args) != 1) {
+		return fmt.Errorf(
+			"invalid qualifier %s(%s)", q.name, strings.Join(q.args, ", "))
+	}
+	return nil
+}
+
+// supportedOvfType returns true if checkOvfType can check the type.
+func supportedOvfType(typ string) bool {
+	switch typ {
+	case "string", "boolean",
+		"uint8", "uint16", "uint32", "uint64",
+		"sint8", "sint16", "sint32", "sint64",
+		"real32", "real64":
+		return true
+	}
+	return false
+}
+
+// checkOvfType returns an error if the value is not valid for the OVF
+// property type.
+func checkOvfType(typ, val string) error {
+	var err error
+	switch typ {
+	case "string":
+	case "boolean":
+		if !strings.EqualFold(val, "true") &&
+			!strings.EqualFold(val, "false") {
+			err = strconv.ErrSyntax
+		}
+	case "uint8", "uint16", "uint32", "uint64":
+		bits, _ := strconv.Atoi(typ[4:])
+		_, err = strconv.ParseUint(val, 10, bits)
+	case "sint8", "sint16", "sint32", "sint64":
+		bits, _ := strconv.Atoi(typ[4:])
+		_, err = strconv.ParseInt(val, 10, bits)
+	case "real32", "real64":
+		bits, _ := strconv.Atoi(typ[4:])
+		_, err = strconv.ParseFloat(val, bits)
+	default:
+		return fmt.Errorf("unsupported type %q", typ)
+	}
+	if err != nil {
+		return fmt.Errorf("value is not a valid %s", typ)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ovfPatchOp is a single change in an OVF environment patch. A nil value
+// removes the property.
+type ovfPatchOp struct {
+	key   string
+	value *string
+}
+
+// unsetOvf removes properties, and their encoding properties, from the
+// OVF environment. Keys that do not exist are ignored.
+func unsetOvf(config Backend, args []string) error {
+	fs := newFlagSet("unset.ovf")
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("invalid number of arguments for unset.ovf")
+	}
+	ops := make([]ovfPatchOp, len(keys))
+	for i, k := range keys {
+		ops[i] = ovfPatchOp{key: k}
+	}
+	return batch(config, func() error {
+		return patchOvfEnv(config, ops)
+	})
+}
+
+// patchOvf applies a document of additions, updates, and removals to the
+// OVF environment, which is written only once.
+func patchOvf(config Backend, args []string) error {
+	fs := newFlagSet("patch.ovf")
+	format := fs.String(
+		"format", "",
+		"The format of the patch: \"json\" or \"env\". The format is "+
+			"detected if omitted.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	var (
+		name = "stdin"
+		buf  []byte
+	)
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "-"):
+		if buf, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("error reading stdin: %v", err)
+		}
+	case len(args) == 1:
+		name = args[0]
+		if buf, err = ioutil.ReadFile(name); err != nil {
+			return fmt.Errorf("failed to read patch: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid number of arguments for patch.ovf")
+	}
+	ops, err := parseOvfPatch(name, *format, buf)
+	if err != nil {
+		return err
+	}
+	return batch(config, func() error {
+		return patchOvfEnv(config, ops)
+	})
+}
+
+// patchOvfEnv applies the changes to the OVF environment and writes it to
+// guestinfo if it was modified. Removing a property also removes its
+// encoding property so that a later value is not decoded with it.
+func patchOvfEnv(config Backend, ops []ovfPatchOp) error {
+	doc, err := getOvfEnvDoc(config)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.value != nil {
+			// The redacted value placeholder keeps the stored value.
+			if *op.value == redactedValue {
+				if _, ok := doc.get(op.key); !ok {
+					return errRedactedValue(op.key)
+				}
+				continue
+			}
+			doc.set(op.key, *op.value)
+			continue
+		}
+		doc.remove(op.key)
+		doc.remove(op.key + encodingSuffix)
+	}
+	return setOvfEnvDoc(config, doc)
+}
+
+// parseOvfPatch parses a JSON object whose values are strings, which set
+// properties, or null, which remove them, or a document of KEY=VAL lines
+// and "-KEY" lines, which remove properties. If format is empty then a
+// document that begins with "{" is treated as JSON.
+func parseOvfPatch(name, format string, buf []byte) ([]ovfPatchOp, error) {
+	format, err := parseDocFormat(format, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []ovfPatchOp
+	if format == "json" {
+		var vals map[string]*string
+		if err := json.Unmarshal(buf, &vals); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", name, err)
+		}
+		keys := make([]string, 0, len(vals))
+		for k := range vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ops = append(ops, ovfPatchOp{key: k, value: vals[k]})
+		}
+		return ops, nil
+	}
+
+	// The lines are parsed like the KEY=VAL lines read by "set-many",
+	// except for the -KEY lines.
+	for n, line := range strings.Split(string(buf), "\n") {
+		if l := strings.TrimSpace(line); strings.HasPrefix(l, "-") {
+			key := strings.TrimSpace(l[1:])
+			if key == "" {
+				return nil, fmt.Errorf(
+					"invalid line in %s:%d: %s", name, n+1, l)
+			}
+			ops = append(ops, ovfPatchOp{key: key})
+			continue
+		}
+		key, val, err := parseEnvLine(name, n+1, line)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			ops = append(ops, ovfPatchOp{key: key, value: &val})
+		}
+	}
+	return ops, nil
+}
@@ -0,0 +1,478 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+const (
+	// ovfEnvNS is the namespace of the OVF environment.
+	ovfEnvNS = "http://schemas.dmtf.org/ovf/environment/1"
+
+	// ovfEnvVmwNS is the namespace of VMware's OVF environment extensions.
+	ovfEnvVmwNS = "http://www.vmware.com/schema/ovfenv"
+)
+
+// ovfEnvDoc is an OVF environment document whose properties may be
+// edited without re-serializing the rest of the document. Namespaces,
+// attributes, whitespace, and unknown sections are preserved, and a
+// document whose properties are unchanged is written byte-for-byte as it
+// was read.
+//
+// govmomi's ovf.Env.MarshalManual is not used to write the document
+// because it does not escape values, drops unknown sections and the
+// environment's ID, and panics if a section is missing.
+type ovfEnvDoc struct {
+	raw string
+
+	// source is the source from which the document was read.
+	source ovfSource
+
+	// props are the Property elements in the PropertySection, in order,
+	// followed by any new properties.
+	props []*ovfEnvProp
+
+	// sectionStart and sectionEnd are the offsets of the content of the
+	// PropertySection, or of the entire element if it is self-closing.
+	// Both are -1 if the document has no PropertySection.
+	sectionStart int
+	sectionEnd   int
+	sectionEmpty bool
+	sectionName  string
+
+	// tail is the text between the last Property element and the
+	// PropertySection end tag.
+	tail string
+
+	// insertAt is the offset at which a missing PropertySection is
+	// written: after the PlatformSection, or before the Environment end
+	// tag if there is no PlatformSection.
+	insertAt int
+
+	// propName, keyAttr, and valueAttr are the qualified names used to
+	// write a new property. If declareNS is true then the prefix of the
+	// attributes is not declared by the document and is declared by each
+	// new property.
+	propName  string
+	keyAttr   string
+	valueAttr string
+	declareNS bool
+}
+
+// ovfEnvProp is a Property element in an ovfEnvDoc.
+type ovfEnvProp struct {
+	// gap is the text between the previous element and this one.
+	gap string
+
+	// raw is the element as it was read. It is empty for a new property.
+	raw string
+
+	// name and attrs are the qualified name and attributes of the element
+	// as it was read.
+	name  string
+	attrs []xml.Attr
+
+	key, value string
+	changed    bool
+	deleted    bool
+}
+
+// parseOvfEnvDoc parses an OVF environment document.
+func parseOvfEnvDoc(raw string) (*ovfEnvDoc, error) {
+	// Validate the document is an OVF environment.
+	var env ovf.Env
+	if err := xml.Unmarshal([]byte(raw), &env); err != nil {
+		return nil, err
+	}
+	doc := &ovfEnvDoc{raw: raw, sectionStart: -1, sectionEnd: -1}
+
+	var (
+		dec       = xml.NewDecoder(strings.NewReader(raw))
+		depth     int
+		envPrefix string
+		oePrefix  string
+		inSection bool
+		prop      *ovfEnvProp
+		propStart int
+		last      int
+	)
+	for {
+		start := int(dec.InputOffset())
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		end := int(dec.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1:
+				envPrefix = t.Name.Space
+				for _, a := range t.Attr {
+					if a.Name.Space == "xmlns" && a.Value == ovfEnvNS {
+						oePrefix = a.Name.Local
+					}
+				}
+			case depth == 2 && t.Name.Local == "PropertySection" &&
+				doc.sectionStart < 0:
+				inSection = true
+				doc.sectionName = qualifiedName(t.Name)
+				doc.sectionStart, last = end, end
+				if strings.HasSuffix(raw[start:end], "/>") {
+					doc.sectionStart, doc.sectionEmpty = start, true
+				}
+			case depth == 3 && inSection && t.Name.Local == "Property":
+				prop = &ovfEnvProp{
+					gap:   raw[last:start],
+					name:  qualifiedName(t.Name),
+					attrs: t.Attr,
+				}
+				propStart = start
+				for _, a := range t.Attr {
+					switch a.Name.Local {
+					case "key":
+						prop.key = a.Value
+						doc.keyAttr = qualifiedName(a.Name)
+					case "value":
+						prop.value = a.Value
+						doc.valueAttr = qualifiedName(a.Name)
+					}
+				}
+				doc.propName = prop.name
+			}
+		case xml.EndElement:
+			switch {
+			case depth == 1 && doc.insertAt == 0:
+				doc.insertAt = start
+			case depth == 2 && t.Name.Local == "PlatformSection":
+				doc.insertAt = end
+			case depth == 2 && inSection:
+				inSection = false
+				doc.sectionEnd = start
+				if doc.sectionEmpty {
+					doc.sectionEnd = end
+				} else {
+					doc.tail = raw[last:start]
+				}
+			case depth == 3 && prop != nil:
+				prop.raw = raw[propStart:end]
+				doc.props = append(doc.props, prop)
+				last = end
+				prop = nil
+			}
+			depth--
+		}
+	}
+
+	// Determine the names used to write a new property.
+	if doc.sectionName == "" {
+		doc.sectionName = qualifiedName(
+			xml.Name{Space: envPrefix, Local: "PropertySection"})
+	}
+	if doc.propName == "" {
+		doc.propName = qualifiedName(
+			xml.Name{Space: envPrefix, Local: "Property"})
+	}
+	if doc.keyAttr == "" || doc.valueAttr == "" {
+		if oePrefix == "" {
+			oePrefix, doc.declareNS = "oe", true
+		}
+		doc.keyAttr = oePrefix + ":key"
+		doc.valueAttr = oePrefix + ":value"
+	}
+	return doc, nil
+}
+
+// qualifiedName returns the name as it appears in the document.
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// get returns the value of the property with the matching key.
+func (d *ovfEnvDoc) get(key string) (string, bool) {
+	if p := d.find(key); p != nil {
+		return p.value, true
+	}
+	return "", false
+}
+
+// keys returns the keys of the properties in the order they appear.
+func (d *ovfEnvDoc) keys() []string {
+	var keys []string
+	for _, p := range d.props {
+		if !p.deleted {
+			keys = append(keys, p.key)
+		}
+	}
+	return keys
+}
+
+// find returns the property with the matching key.
+func (d *ovfEnvDoc) find(key string) *ovfEnvProp {
+	for _, p := range d.props {
+		if !p.deleted && strings.EqualFold(p.key, key) {
+			return p
+		}
+	}
+	return nil
+}
+
+// set updates the value of the property with the matching key or adds
+// the property if no key matches. A new property is indented like the
+// last property in the document.
+func (d *ovfEnvDoc) set(key, value string) {
+	if p := d.find(key); p != nil {
+		if p.value != value {
+			p.value, p.changed = value, true
+		}
+		return
+	}
+	var gap string
+	if n := len(d.props); n > 0 {
+		gap = d.props[n-1].gap
+		if i := strings.LastIndex(gap, "\n"); i >= 0 {
+			gap = gap[i:]
+		}
+		if strings.TrimSpace(gap) != "" {
+			gap = ""
+		}
+	}
+	d.props = append(d.props, &ovfEnvProp{
+		gap:     gap,
+		key:     key,
+		value:   value,
+		changed: true,
+	})
+}
+
+// remove removes the property with the matching key. It returns false if
+// no key matches.
+func (d *ovfEnvDoc) remove(key string) bool {
+	if p := d.find(key); p != nil {
+		p.deleted = true
+		return true
+	}
+	return false
+}
+
+// modified returns true if a property was added, updated, or removed.
+func (d *ovfEnvDoc) modified() bool {
+	for _, p := range d.props {
+		if p.changed || p.deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the document. The document is returned as it was read
+// if no properties were modified.
+func (d *ovfEnvDoc) String() string {
+	if !d.modified() {
+		return d.raw
+	}
+	var buf bytes.Buffer
+	switch {
+	case d.sectionStart < 0:
+		buf.WriteString(d.raw[:d.insertAt])
+		fmt.Fprintf(&buf, "<%s>", d.sectionName)
+		d.writeProps(&buf)
+		fmt.Fprintf(&buf, "</%s>", d.sectionName)
+		buf.WriteString(d.raw[d.insertAt:])
+	case d.sectionEmpty:
+		buf.WriteString(d.raw[:d.sectionStart])
+		fmt.Fprintf(&buf, "<%s>", d.sectionName)
+		d.writeProps(&buf)
+		fmt.Fprintf(&buf, "</%s>", d.sectionName)
+		buf.WriteString(d.raw[d.sectionEnd:])
+	default:
+		buf.WriteString(d.raw[:d.sectionStart])
+		d.writeProps(&buf)
+		buf.WriteString(d.tail)
+		buf.WriteString(d.raw[d.sectionEnd:])
+	}
+	return buf.String()
+}
+
+// writeProps writes the Property elements. The elements that were not
+// modified are written as they were read.
+func (d *ovfEnvDoc) writeProps(buf *bytes.Buffer) {
+	for _, p := range d.props {
+		if p.deleted {
+			// Preserve any comments that preceded the property.
+			if strings.TrimSpace(p.gap) != "" {
+				buf.WriteString(p.gap)
+			}
+			continue
+		}
+		buf.WriteString(p.gap)
+		switch {
+		case !p.changed:
+			buf.WriteString(p.raw)
+		case p.raw != "":
+			// Rewrite the element with its original name and attributes.
+			fmt.Fprintf(buf, "<%s", p.name)
+			for _, a := range p.attrs {
+				val := a.Value
+				if a.Name.Local == "value" {
+					val = p.value
+				}
+				writeXMLAttr(buf, qualifiedName(a.Name), val)
+			}
+			buf.WriteString("/>")
+		default:
+			fmt.Fprintf(buf, "<%s", d.propName)
+			if d.declareNS {
+				writeXMLAttr(buf, "xmlns:oe", ovfEnvNS)
+			}
+			writeXMLAttr(buf, d.keyAttr, p.key)
+			writeXMLAttr(buf, d.valueAttr, p.value)
+			buf.WriteString("/>")
+		}
+	}
+}
+
+// writeXMLAttr writes an attribute with its value escaped. Tabs and line
+// breaks are written as character references so they survive attribute
+// value normalization. Quotes are written as &quot; as they are in the
+// OVF descriptors.
+func writeXMLAttr(buf *bytes.Buffer, name, val string) {
+	var esc bytes.Buffer
+	xml.EscapeText(&esc, []byte(val))
+	fmt.Fprintf(buf, " %s=\"%s\"", name,
+		strings.Replace(esc.String(), "&#34;", "&quot;", -1))
+}
+
+// marshalOvfEnv returns an OVF environment document for the environment.
+// The document has the same layout as one written by ovf.Env's
+// MarshalManual, but values are escaped, the ID is preserved, and the
+// sections are optional.
+func marshalOvfEnv(env *ovf.Env) string {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString("<Environment")
+	for _, a := range []struct{ name, val string }{
+		{"xmlns", ovfEnvNS},
+		{"xmlns:xsi", "http://www.w3.org/2001/XMLSchema-instance"},
+		{"xmlns:oe", ovfEnvNS},
+		{"xmlns:ve", ovfEnvVmwNS},
+		{"oe:id", env.ID},
+		{"ve:esxId", env.EsxID},
+	} {
+		fmt.Fprintf(&buf, "\n\t\t%s=\"", a.name)
+		xml.EscapeText(&buf, []byte(a.val))
+		buf.WriteByte('"')
+	}
+	buf.WriteString(">")
+	if p := env.Platform; p != nil {
+		buf.WriteString("<PlatformSection>")
+		for _, e := range []struct{ name, val string }{
+			{"Kind", p.Kind},
+			{"Version", p.Version},
+			{"Vendor", p.Vendor},
+			{"Locale", p.Locale},
+		} {
+			fmt.Fprintf(&buf, "\n\t\t<%s>", e.name)
+			xml.EscapeText(&buf, []byte(e.val))
+			fmt.Fprintf(&buf, "</%s>", e.name)
+		}
+		buf.WriteString("\n\t\t</PlatformSection>")
+	}
+	if env.Property != nil {
+		buf.WriteString("<PropertySection>")
+		for _, p := range env.Property.Properties {
+			buf.WriteString("<Property")
+			writeXMLAttr(&buf, "oe:key", p.Key)
+			writeXMLAttr(&buf, "oe:value", p.Value)
+			buf.WriteString("/>")
+		}
+		buf.WriteString("</PropertySection>")
+	}
+	buf.WriteString("</Environment>")
+	return buf.String()
+}
+
+// getOvfEnvDoc returns the OVF environment document from the first of the
+// sources that has one.
+func getOvfEnvDoc(config Backend) (*ovfEnvDoc, error) {
+	doc, err := getOvfEnvDocIfSet(config)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf(
+			"OVF environment not found in %s", ovfEnvSources.String())
+	}
+	return doc, nil
+}
+
+// getOvfEnvDocIfSet is like getOvfEnvDoc but returns nil if none of the
+// sources has an OVF environment.
+func getOvfEnvDocIfSet(config Backend) (*ovfEnvDoc, error) {
+	ovfEnvSz, src, err := readOvfEnvSource(config)
+	if err != nil {
+		return nil, err
+	}
+	if ovfEnvSz == "" {
+		return nil, nil
+	}
+	doc, err := parseOvfEnvDoc(ovfEnvSz)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to unmarshall OVF environment from %s: %v", src, err)
+	}
+	doc.source = src
+	return doc, nil
+}
+
+// setOvfEnvDoc writes the OVF environment document to guestinfo if any
+// of its properties were modified. The ISO and file sources are read-only,
+// so a document read from one of them is written to guestinfo as well,
+// along with the source and the digest of the document read from it. The
+// source is read instead of the copy once its document changes.
+func setOvfEnvDoc(config Backend, doc *ovfEnvDoc) error {
+	if !doc.modified() {
+		return nil
+	}
+	return batch(config, func() error {
+		if err := config.SetString(
+			"guestinfo.ovfEnv", doc.String()); err != nil {
+			return err
+		}
+		if doc.source.kind != "iso" && doc.source.kind != "file" {
+			return nil
+		}
+		if err := config.SetString(
+			ovfEnvSourceKey, doc.source.String()); err != nil {
+			return err
+		}
+		return config.SetString(ovfEnvDigestKey, ovfEnvDigest(doc.raw))
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testOvfEnv is an OVF environment with a comment, a section rpctool
+// does not know about, and a prefixed PropertySection.
+const testOvfEnv = `<?xml version="1.0" encoding="UTF-8"?>
+<Environment
+     xmlns="http://schemas.dmtf.org/ovf/environment/1"
+     xmlns:oe="http://schemas.dmtf.org/ovf/environment/1"
+     xmlns:ve="http://www.vmware.com/schema/ovfenv"
+     oe:id=""
+     ve:vCenterId="vm-42">
+   <PlatformSection>
+      <Kind>VMware ESXi</Kind>
+   </PlatformSection>
+   <!-- the sk8 properties -->
+   <PropertySection>
+         <Property oe:key="NUM_NODES" oe:value="2"/>
+         <Property oe:key="K8S_VERSION" oe:value="release/stable"/>
+         <Property oe:key="VSPHERE_PASSWORD" oe:value="a&amp;b&lt;c&quot;"/>
+   </PropertySection>
+   <ve:EthernetAdapterSection>
+      <ve:Adapter ve:mac="00:50:56:00:00:01" ve:network="VM Network"/>
+   </ve:EthernetAdapterSection>
+</Environment>
+`
+
+func TestOvfEnvDoc(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		edit     func(d *ovfEnvDoc)
+		wantKeys []string
+		wantVals map[string]string
+	}{
+		{
+			name:     "unmodified",
+			raw:      testOvfEnv,
+			edit:     func(d *ovfEnvDoc) {},
+			wantKeys: []string{"NUM_NODES", "K8S_VERSION", "VSPHERE_PASSWORD"},
+			wantVals: map[string]string{"VSPHERE_PASSWORD": `a&b<c"`},
+		},
+		{
+			name:     "set existing",
+			raw:      testOvfEnv,
+			edit:     func(d *ovfEnvDoc) { d.set("num_nodes", "3") },
+			wantKeys: []string{"NUM_NODES", "K8S_VERSION", "VSPHERE_PASSWORD"},
+			wantVals: map[string]string{"NUM_NODES": "3"},
+		},
+		{
+			name: "add",
+			raw:  testOvfEnv,
+			edit: func(d *ovfEnvDoc) { d.set("DEBUG", "<true>") },
+			wantKeys: []string{
+				"NUM_NODES", "K8S_VERSION", "VSPHERE_PASSWORD", "DEBUG"},
+			wantVals: map[string]string{"DEBUG": "<true>"},
+		},
+		{
+			name:     "remove",
+			raw:      testOvfEnv,
+			edit:     func(d *ovfEnvDoc) { d.remove("K8S_VERSION") },
+			wantKeys: []string{"NUM_NODES", "VSPHERE_PASSWORD"},
+		},
+		{
+			name: "add to a document without a PropertySection",
+			raw: `<Environment xmlns="http://schemas.dmtf.org/ovf/environment/1">` +
+				`<PlatformSection><Kind>VMware ESXi</Kind></PlatformSection>` +
+				`</Environment>`,
+			edit:     func(d *ovfEnvDoc) { d.set("NUM_NODES", "1") },
+			wantKeys: []string{"NUM_NODES"},
+			wantVals: map[string]string{"NUM_NODES": "1"},
+		},
+		{
+			name: "add to an empty PropertySection",
+			raw: `<Environment xmlns="http://schemas.dmtf.org/ovf/environment/1">` +
+				`<PropertySection/></Environment>`,
+			edit:     func(d *ovfEnvDoc) { d.set("NUM_NODES", "1") },
+			wantKeys: []string{"NUM_NODES"},
+			wantVals: map[string]string{"NUM_NODES": "1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parseOvfEnvDoc(tc.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.edit(doc)
+			out := doc.String()
+			if !doc.modified() && out != tc.raw {
+				t.Errorf("unmodified document changed:\n%s", out)
+			}
+			for _, s := range []string{
+				"<!-- the sk8 properties -->",
+				`<ve:Adapter ve:mac="00:50:56:00:00:01"`,
+			} {
+				if strings.Contains(tc.raw, s) && !strings.Contains(out, s) {
+					t.Errorf("document lost %s:\n%s", s, out)
+				}
+			}
+
+			// The document written is read back with the same properties.
+			doc, err = parseOvfEnvDoc(out)
+			if err != nil {
+				t.Fatalf("failed to parse the written document: %v\n%s",
+					err, out)
+			}
+			if keys := doc.keys(); !reflect.DeepEqual(keys, tc.wantKeys) {
+				t.Errorf("keys() = %v, want %v", keys, tc.wantKeys)
+			}
+			for k, want := range tc.wantVals {
+				if val, ok := doc.get(k); !ok || val != want {
+					t.Errorf("get(%q) = %q, %v, want %q", k, val, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOvfEnvDocInvalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		raw  string
+	}{
+		{name: "empty", raw: ""},
+		{name: "not xml", raw: "NUM_NODES=1"},
+		{name: "unterminated", raw: `<Environment><PropertySection>`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseOvfEnvDoc(tc.raw); err == nil {
+				t.Errorf("parseOvfEnvDoc(%q) succeeded, want error", tc.raw)
+			}
+		})
+	}
+}
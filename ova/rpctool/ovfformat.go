@@ -0,0 +1,292 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+// ovfEnvView is the compact JSON schema of the OVF environment used by
+// "get.ovf" and "set.ovf":
+//
+//	{
+//	  "transport": "...",
+//	  "id": "...",
+//	  "esxId": "...",
+//	  "platform": {"kind": "...", "version": "...", ...},
+//	  "properties": {"KEY": "VAL", ...}
+//	}
+//
+// The transport is the source from which the OVF environment was read,
+// ex. "guestinfo" or "iso:/media/cdrom/ovf-env.xml". It is ignored by
+// "set.ovf".
+type ovfEnvView struct {
+	Transport  string           `json:"transport,omitempty"`
+	ID         string           `json:"id,omitempty"`
+	EsxID      string           `json:"esxId,omitempty"`
+	Platform   *ovfPlatformView `json:"platform,omitempty"`
+	Properties ovfPropsView     `json:"properties"`
+}
+
+// ovfPlatformView is the PlatformSection in an ovfEnvView.
+type ovfPlatformView struct {
+	Kind    string `json:"kind,omitempty"`
+	Version string `json:"version,omitempty"`
+	Vendor  string `json:"vendor,omitempty"`
+	Locale  string `json:"locale,omitempty"`
+}
+
+// ovfPropsView is the properties in an ovfEnvView. The properties are
+// encoded as a JSON object with the keys in the order they appear in the
+// OVF environment.
+type ovfPropsView []ovf.EnvProperty
+
+// MarshalJSON encodes the properties as a JSON object.
+func (v ovfPropsView) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, p := range v {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		jk, _ := json.Marshal(p.Key)
+		jv, _ := json.Marshal(p.Value)
+		buf.Write(jk)
+		buf.WriteByte(':')
+		buf.Write(jv)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes a JSON object of string values, preserving the
+// order of its keys.
+func (v *ovfPropsView) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("properties must be an object")
+	}
+	*v = nil
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var p ovf.EnvProperty
+		p.Key = tok.(string)
+		if err := dec.Decode(&p.Value); err != nil {
+			return fmt.Errorf("invalid value for property %s: %v", p.Key, err)
+		}
+		*v = append(*v, p)
+	}
+	return nil
+}
+
+// newOvfEnvView returns the compact view of the OVF environment.
+func newOvfEnvView(env *ovf.Env) *ovfEnvView {
+	v := &ovfEnvView{ID: env.ID, EsxID: env.EsxID, Properties: ovfPropsView{}}
+	if p := env.Platform; p != nil {
+		v.Platform = &ovfPlatformView{
+			Kind:    p.Kind,
+			Version: p.Version,
+			Vendor:  p.Vendor,
+			Locale:  p.Locale,
+		}
+	}
+	if env.Property != nil {
+		v.Properties = ovfPropsView(env.Property.Properties)
+	}
+	return v
+}
+
+// env returns the OVF environment described by the view.
+func (v *ovfEnvView) env() *ovf.Env {
+	env := &ovf.Env{
+		ID:       v.ID,
+		EsxID:    v.EsxID,
+		Property: &ovf.PropertySection{Properties: v.Properties},
+	}
+	if p := v.Platform; p != nil {
+		env.Platform = &ovf.PlatformSection{
+			Kind:    p.Kind,
+			Version: p.Version,
+			Vendor:  p.Vendor,
+			Locale:  p.Locale,
+		}
+	}
+	return env
+}
+
+// writeOvfEnv writes the OVF environment, with the values of sensitive
+// properties redacted, in the given format. The "xml" format is the
+// OVF environment document itself. If legacy is true then the "json"
+// and "xml" formats are the encodings of govmomi's ovf.Env.
+func writeOvfEnv(
+	w io.Writer, format string, legacy bool, config Backend) error {
+
+	if format == "xml" && !legacy {
+		doc, err := getOvfEnvDoc(config)
+		if err != nil {
+			return err
+		}
+		for _, k := range doc.keys() {
+			if v, _ := doc.get(k); redact(k, v) != v {
+				doc.set(k, redactedValue)
+			}
+		}
+		_, err = io.WriteString(w, doc.String())
+		return err
+	}
+
+	ovfEnv, src, err := getOvfEnvSource(config)
+	if err != nil {
+		return err
+	}
+	ovfEnv = redactOvfEnv(ovfEnv)
+
+	if legacy {
+		var enc encoder
+		switch format {
+		case "json":
+			jsonEnc := json.NewEncoder(w)
+			jsonEnc.SetIndent("", "  ")
+			enc = jsonEnc
+		case "xml":
+			xmlEnc := xml.NewEncoder(w)
+			xmlEnc.Indent("", "  ")
+			enc = xmlEnc
+		default:
+			return fmt.Errorf("invalid ovf.format for ovf.legacy: %s", format)
+		}
+		if err := enc.Encode(ovfEnv); err != nil {
+			return fmt.Errorf("failed to encode OVF environment: %v", err)
+		}
+		return nil
+	}
+
+	v := newOvfEnvView(ovfEnv)
+	v.Transport = src.String()
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		jv, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode OVF environment: %v", err)
+		}
+		buf.Write(jv)
+		buf.WriteByte('\n')
+	case "yaml":
+		fmt.Fprintf(&buf, "transport: %s\n", yamlQuote(v.Transport))
+		if v.ID != "" {
+			fmt.Fprintf(&buf, "id: %s\n", yamlQuote(v.ID))
+		}
+		if v.EsxID != "" {
+			fmt.Fprintf(&buf, "esxId: %s\n", yamlQuote(v.EsxID))
+		}
+		if p := v.Platform; p != nil {
+			buf.WriteString("platform:\n")
+			for _, e := range []struct{ name, val string }{
+				{"kind", p.Kind},
+				{"version", p.Version},
+				{"vendor", p.Vendor},
+				{"locale", p.Locale},
+			} {
+				if e.val != "" {
+					fmt.Fprintf(&buf, "  %s: %s\n", e.name, yamlQuote(e.val))
+				}
+			}
+		}
+		if len(v.Properties) == 0 {
+			buf.WriteString("properties: {}\n")
+		} else {
+			buf.WriteString("properties:\n")
+		}
+		for _, p := range v.Properties {
+			fmt.Fprintf(&buf, "  %s: %s\n", yamlKey(p.Key), yamlQuote(p.Value))
+		}
+	case "env":
+		fmt.Fprintf(&buf, "# transport: %s\n", v.Transport)
+		for _, p := range v.Properties {
+			if !envNameRx.MatchString(p.Key) {
+				return fmt.Errorf(
+					"invalid environment variable name: %s", p.Key)
+			}
+			fmt.Fprintf(&buf, "%s=%s\n", p.Key, shellQuote(p.Value))
+		}
+	default:
+		return fmt.Errorf("invalid ovf.format: %s", format)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// readOvfEnv reads an OVF environment in the given format and returns the
+// OVF environment document to store in guestinfo. An "xml" document is
+// validated and returned as it was read. If legacy is true then the
+// "json" and "xml" formats are the encodings of govmomi's ovf.Env.
+func readOvfEnv(r io.Reader, format string, legacy bool) (string, error) {
+	if legacy {
+		var dec decoder
+		switch format {
+		case "json":
+			dec = json.NewDecoder(r)
+		case "xml":
+			dec = xml.NewDecoder(r)
+		default:
+			return "", fmt.Errorf(
+				"invalid ovf.format for ovf.legacy: %s", format)
+		}
+		var ovfEnv ovf.Env
+		if err := dec.Decode(&ovfEnv); err != nil {
+			return "", fmt.Errorf(
+				"failed to decode OVF environment as %s: %v", format, err)
+		}
+		return marshalOvfEnv(&ovfEnv), nil
+	}
+
+	switch format {
+	case "json":
+		// Unknown fields are rejected so the legacy schema is not
+		// mistaken for an empty OVF environment.
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		var v ovfEnvView
+		if err := dec.Decode(&v); err != nil {
+			return "", fmt.Errorf(
+				"failed to decode OVF environment as json: %v", err)
+		}
+		return marshalOvfEnv(v.env()), nil
+	case "xml":
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("failed to read OVF environment: %v", err)
+		}
+		if _, err := parseOvfEnvDoc(string(buf)); err != nil {
+			return "", fmt.Errorf(
+				"failed to decode OVF environment as xml: %v", err)
+		}
+		return string(buf), nil
+	default:
+		return "", fmt.Errorf("invalid ovf.format for set.ovf: %s", format)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestOvfEnvRoundTrip checks that the output of "get.ovf" may be written
+// back with "set.ovf" without changing the properties, including the
+// sensitive ones that were redacted.
+func TestOvfEnvRoundTrip(t *testing.T) {
+	defer func(s ovfSources) { ovfEnvSources = s }(ovfEnvSources)
+	ovfEnvSources = ovfSources{{kind: "guestinfo"}}
+
+	testCases := []struct {
+		format string
+		legacy bool
+	}{
+		{format: "json"},
+		{format: "xml"},
+		{format: "json", legacy: true},
+		{format: "xml", legacy: true},
+	}
+	for _, tc := range testCases {
+		name := tc.format
+		if tc.legacy {
+			name += "/legacy"
+		}
+		t.Run(name, func(t *testing.T) {
+			b := newMemoryBackend()
+			b.SetString("guestinfo.ovfEnv", testOvfEnv)
+			want, err := parseOvfEnvDoc(testOvfEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := writeOvfEnv(&buf, tc.format, tc.legacy, b); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(buf.String(), "a&b<c") ||
+				!strings.Contains(buf.String(), redactedValue) {
+				t.Errorf("VSPHERE_PASSWORD is not redacted:\n%s", buf.String())
+			}
+
+			raw, err := readOvfEnv(&buf, tc.format, tc.legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, err = restoreRedactedOvfEnv(b, raw); err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseOvfEnvDoc(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range want.keys() {
+				wv, _ := want.get(k)
+				if gv, ok := got.get(k); !ok || gv != wv {
+					t.Errorf("%s = %q, %v, want %q", k, gv, ok, wv)
+				}
+			}
+			if len(got.keys()) != len(want.keys()) {
+				t.Errorf("keys = %v, want %v", got.keys(), want.keys())
+			}
+		})
+	}
+}
+
+func TestReadOvfEnvInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		format string
+		legacy bool
+		in     string
+	}{
+		{name: "legacy schema as json", format: "json",
+			in: `{"Property":{"Properties":[]}}`},
+		{name: "properties not an object", format: "json",
+			in: `{"properties":[]}`},
+		{name: "invalid xml", format: "xml", in: "<Environment>"},
+		{name: "yaml", format: "yaml", in: "properties: {}"},
+		{name: "legacy yaml", format: "yaml", legacy: true, in: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := readOvfEnv(
+				strings.NewReader(tc.in), tc.format, tc.legacy); err == nil {
+				t.Errorf("readOvfEnv(%q) succeeded, want error", tc.in)
+			}
+		})
+	}
+}
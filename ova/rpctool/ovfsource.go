@@ -0,0 +1,221 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ovfEnvFileName is the name of the OVF environment document on the
+// CD-ROM attached by the ISO transport.
+const ovfEnvFileName = "ovf-env.xml"
+
+// ovfEnvSourceKey and ovfEnvDigestKey record the "iso" or "file" source
+// from which the OVF environment in guestinfo.ovfEnv was copied, and the
+// SHA-256 digest of the document read from it.
+const (
+	ovfEnvSourceKey = "guestinfo.ovfEnv.source"
+	ovfEnvDigestKey = "guestinfo.ovfEnv.source.sha256"
+)
+
+// isoMountPoints are the directories searched for ovf-env.xml by the
+// "iso" source when a mount point is not specified.
+var isoMountPoints = []string{
+	"/media/cdrom",
+	"/media/cdrom0",
+	"/mnt/cdrom",
+	"/mnt/cdrom0",
+}
+
+// defaultOvfSources is guestinfo, the VMware tools transport, followed by
+// the ISO transport.
+var defaultOvfSources = ovfSources{{kind: "guestinfo"}, {kind: "iso"}}
+
+// ovfEnvSources is the order in which the OVF environment is discovered.
+// It is set by the global flag -ovf.source.
+var ovfEnvSources = defaultOvfSources
+
+// ovfSource is a location from which the OVF environment may be read.
+type ovfSource struct {
+	// kind is "guestinfo", "iso", or "file".
+	kind string
+
+	// path is the mount point of an "iso" source or the path of a "file"
+	// source. An "iso" source without a path searches isoMountPoints.
+	path string
+}
+
+func (s ovfSource) String() string {
+	if s.path == "" {
+		return s.kind
+	}
+	return s.kind + ":" + s.path
+}
+
+// ovfSources is an ordered list of OVF environment sources. It implements
+// flag.Value as a comma-separated list.
+type ovfSources []ovfSource
+
+func (s *ovfSources) String() string {
+	var names []string
+	for _, src := range *s {
+		names = append(names, src.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (s *ovfSources) Set(val string) error {
+	var sources ovfSources
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		src := ovfSource{kind: v}
+		if i := strings.IndexByte(v, ':'); i >= 0 {
+			src = ovfSource{kind: v[:i], path: v[i+1:]}
+		}
+		switch {
+		case src.kind == "guestinfo" && src.path == "":
+		case src.kind == "iso":
+		case src.kind == "file" && src.path != "":
+		default:
+			return fmt.Errorf("invalid source: %s", v)
+		}
+		sources = append(sources, src)
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no sources")
+	}
+	*s = sources
+	return nil
+}
+
+// readOvfEnvSource returns the first OVF environment document found in
+// the sources, and the source from which it was read. An empty document
+// is returned if no source has the OVF environment.
+func readOvfEnvSource(config Backend) (string, ovfSource, error) {
+	for _, src := range ovfEnvSources {
+		switch src.kind {
+		case "guestinfo":
+			val, err := config.String("guestinfo.ovfEnv", "")
+			if err != nil {
+				return "", src, fmt.Errorf(
+					"failed to get guestinfo.ovfEnv: %v", err)
+			}
+			if strings.TrimSpace(val) != "" {
+				cval, csrc, err := changedOvfEnvSource(config)
+				if err != nil {
+					return "", src, err
+				}
+				if cval != "" {
+					return cval, csrc, nil
+				}
+				return val, src, nil
+			}
+		case "iso":
+			dirs := isoMountPoints
+			if src.path != "" {
+				dirs = []string{src.path}
+			}
+			for _, dir := range dirs {
+				path := filepath.Join(dir, ovfEnvFileName)
+				val, err := readOvfEnvFile(path)
+				if err != nil {
+					return "", src, err
+				}
+				if val != "" {
+					return val, ovfSource{kind: "iso", path: path}, nil
+				}
+			}
+		case "file":
+			val, err := readOvfEnvFile(src.path)
+			if err != nil {
+				return "", src, err
+			}
+			if val != "" {
+				return val, src, nil
+			}
+		}
+	}
+	return "", ovfSource{}, nil
+}
+
+// changedOvfEnvSource returns the OVF environment document of the source
+// from which guestinfo.ovfEnv was copied if the source's document changed
+// since, ex. because the vApp properties were edited and a new ISO was
+// attached, so the copy does not shadow the source. An empty document is
+// returned if guestinfo.ovfEnv was not copied from a source, or if the
+// source is unchanged or no longer has a document.
+func changedOvfEnvSource(config Backend) (string, ovfSource, error) {
+	rec, err := config.String(ovfEnvSourceKey, "")
+	if err != nil {
+		return "", ovfSource{}, fmt.Errorf(
+			"failed to get %s: %v", ovfEnvSourceKey, err)
+	}
+	if isUnset(rec) {
+		return "", ovfSource{}, nil
+	}
+	rec = strings.TrimRight(rec, "\n")
+	i := strings.IndexByte(rec, ':')
+	if i < 0 {
+		return "", ovfSource{}, fmt.Errorf(
+			"invalid %s: %s", ovfEnvSourceKey, rec)
+	}
+	src := ovfSource{kind: rec[:i], path: rec[i+1:]}
+	if (src.kind != "iso" && src.kind != "file") || src.path == "" {
+		return "", ovfSource{}, fmt.Errorf(
+			"invalid %s: %s", ovfEnvSourceKey, rec)
+	}
+	digest, err := config.String(ovfEnvDigestKey, "")
+	if err != nil {
+		return "", ovfSource{}, fmt.Errorf(
+			"failed to get %s: %v", ovfEnvDigestKey, err)
+	}
+	val, err := readOvfEnvFile(src.path)
+	if err != nil || val == "" ||
+		ovfEnvDigest(val) == strings.TrimRight(digest, "\n") {
+		return "", ovfSource{}, err
+	}
+	return val, src, nil
+}
+
+// ovfEnvDigest returns the hex-encoded SHA-256 digest of the OVF
+// environment document.
+func ovfEnvDigest(val string) string {
+	sum := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(sum[:])
+}
+
+// readOvfEnvFile returns the contents of the file at path, or an empty
+// string if the file does not exist.
+func readOvfEnvFile(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if strings.TrimSpace(string(buf)) == "" {
+		return "", nil
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOvfSourcesSet(t *testing.T) {
+	testCases := []struct {
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{val: "guestinfo,iso", want: "guestinfo,iso"},
+		{val: " iso:/mnt/cd , file:/tmp/ovf-env.xml", want: "iso:/mnt/cd,file:/tmp/ovf-env.xml"},
+		{val: "guestinfo:x", wantErr: true},
+		{val: "file", wantErr: true},
+		{val: "cdrom", wantErr: true},
+		{val: ",", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.val, func(t *testing.T) {
+			var s ovfSources
+			err := s.Set(tc.val)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v",
+					tc.val, err, tc.wantErr)
+			}
+			if !tc.wantErr && s.String() != tc.want {
+				t.Errorf("String() = %q, want %q", s.String(), tc.want)
+			}
+		})
+	}
+}
+
+// TestOvfEnvSourceCopy checks that a modified OVF environment read from a
+// file is copied to guestinfo, and that the copy is read until the file
+// changes.
+func TestOvfEnvSourceCopy(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, ovfEnvFileName)
+	if err := ioutil.WriteFile(path, []byte(testOvfEnv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s ovfSources) { ovfEnvSources = s }(ovfEnvSources)
+	ovfEnvSources = ovfSources{{kind: "guestinfo"}, {kind: "file", path: path}}
+
+	testCases := []struct {
+		name       string
+		edit       func(t *testing.T, b Backend)
+		wantSource string
+		wantNodes  string
+	}{
+		{
+			name:       "read from the file",
+			edit:       func(t *testing.T, b Backend) {},
+			wantSource: "file:" + path,
+			wantNodes:  "2",
+		},
+		{
+			name: "modified copy in guestinfo",
+			edit: func(t *testing.T, b Backend) {
+				doc, err := getOvfEnvDoc(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				doc.set("NUM_NODES", "3")
+				if err := setOvfEnvDoc(b, doc); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSource: "guestinfo",
+			wantNodes:  "3",
+		},
+		{
+			name: "file changed",
+			edit: func(t *testing.T, b Backend) {
+				buf := strings.Replace(testOvfEnv,
+					`oe:value="2"`, `oe:value="4"`, 1)
+				if err := ioutil.WriteFile(
+					path, []byte(buf), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSource: "file:" + path,
+			wantNodes:  "4",
+		},
+		{
+			name: "file removed",
+			edit: func(t *testing.T, b Backend) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantSource: "guestinfo",
+			wantNodes:  "3",
+		},
+	}
+
+	// The cases are steps that share the backend.
+	b := newMemoryBackend()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.edit(t, b)
+			raw, src, err := readOvfEnvSource(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.String() != tc.wantSource {
+				t.Errorf("source = %s, want %s", src, tc.wantSource)
+			}
+			doc, err := parseOvfEnvDoc(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val, _ := doc.get("NUM_NODES"); val != tc.wantNodes {
+				t.Errorf("NUM_NODES = %q, want %q", val, tc.wantNodes)
+			}
+		})
+	}
+}
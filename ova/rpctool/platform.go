@@ -0,0 +1,249 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/vmw-guestinfo/bdoor"
+	"github.com/vmware/vmw-guestinfo/vmcheck"
+)
+
+// defaultDMIDir is where the kernel exposes the SMBIOS system information.
+const defaultDMIDir = "/sys/class/dmi/id"
+
+// vmxTypes are the product types reported by the backdoor's GetVersion
+// command in ECX.
+var vmxTypes = map[uint32]string{
+	0: "unset",
+	1: "express",
+	2: "esx",
+	3: "server",
+	4: "workstation",
+	5: "workstation-enterprise",
+}
+
+// platformInfo is the report printed by the platform command.
+type platformInfo struct {
+	// VMware is true if the CPU reports the VMware hypervisor.
+	VMware bool `json:"vmware"`
+
+	// Backdoor is the hypervisor version reported by the backdoor, if
+	// the backdoor is available.
+	Backdoor *platformBackdoor `json:"backdoor,omitempty"`
+
+	// UUID is the VM's BIOS UUID, the UUID vSphere reports as the VM's
+	// config.uuid.
+	UUID string `json:"uuid,omitempty"`
+
+	// Serial and ProductUUID are the DMI product_serial and product_uuid
+	// as the kernel reports them.
+	Serial      string `json:"serial,omitempty"`
+	ProductUUID string `json:"productUuid,omitempty"`
+
+	// ProductUUIDSwapped is true if ProductUUID is the BIOS UUID with the
+	// byte order of its first three fields reversed.
+	ProductUUIDSwapped bool `json:"productUuidSwapped,omitempty"`
+
+	// Platform is the OVF environment's PlatformSection, if any.
+	Platform *ovfPlatformView `json:"platform,omitempty"`
+}
+
+// platformBackdoor is the result of the backdoor's GetVersion command.
+type platformBackdoor struct {
+	Version uint32 `json:"version"`
+	Product string `json:"product"`
+}
+
+// platform prints the hypervisor and the VM's identity. The backend is
+// created by the command so that the report may be printed outside of a
+// VM, in which case the OVF PlatformSection is omitted.
+func platform(backendName string, args []string) error {
+	fs := newFlagSet("platform")
+	dmiDir := fs.String(
+		"dmi", defaultDMIDir,
+		"The directory with the DMI product_serial and product_uuid.")
+	uuidOnly := fs.Bool(
+		"uuid", false,
+		"Print only the VM's BIOS UUID.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments for platform")
+	}
+
+	var info platformInfo
+	info.Serial = readDMI(*dmiDir, "product_serial")
+	info.ProductUUID = strings.ToLower(readDMI(*dmiDir, "product_uuid"))
+	info.UUID, info.ProductUUIDSwapped, err = biosUUID(
+		info.Serial, info.ProductUUID)
+	if *uuidOnly {
+		if err != nil {
+			return err
+		}
+		fmt.Println(info.UUID)
+		return nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+
+	info.VMware = vmcheck.IsVirtualCPU()
+	if info.VMware {
+		if isVM, err := vmcheck.IsVirtualWorld(); err != nil {
+			fmt.Fprintf(
+				os.Stderr, "warning: failed to open backdoor: %v\n", err)
+		} else if isVM {
+			p := &bdoor.BackdoorProto{}
+			p.CX.AsUInt32().SetWord(bdoor.CommandGetVersion)
+			out := p.InOut()
+			typ := out.CX.AsUInt32().Word()
+			info.Backdoor = &platformBackdoor{
+				Version: out.AX.AsUInt32().Word(),
+				Product: vmxTypes[typ],
+			}
+			if info.Backdoor.Product == "" {
+				info.Backdoor.Product = fmt.Sprintf("unknown(%d)", typ)
+			}
+		}
+	}
+
+	if backend, err := newBackend(backendName); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	} else {
+		ovfEnv, err := getOvfEnvIfSet(&valueBackend{Backend: backend})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		} else if v := newOvfEnvView(ovfEnv); v.Platform != nil {
+			info.Platform = v.Platform
+		}
+	}
+
+	buf, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(buf))
+	return nil
+}
+
+// readDMI returns the trimmed contents of the DMI file, or an empty
+// string if the file cannot be read, ex. because it is readable only by
+// root.
+func readDMI(dir, name string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// biosUUID returns the VM's BIOS UUID. The UUID is parsed from the DMI
+// serial, ex. "VMware-42 1e 6a 7b 1c 2d 3e 4f-5a 6b 7c 8d 9e 0f 1a 2b",
+// which VMware always writes in the BIOS UUID's byte order. If the
+// serial is not a VMware serial then the DMI product UUID is used as is.
+//
+// Depending on the VM's hardware version and the kernel's SMBIOS version,
+// the product UUID may have the byte order of its first three fields
+// reversed. The second return value is true if that is the case.
+func biosUUID(serial, productUUID string) (string, bool, error) {
+	if serial == "" && productUUID == "" {
+		return "", false, fmt.Errorf("failed to read the DMI serial and UUID")
+	}
+	uuid, serialErr := parseVMwareSerial(serial)
+	if serialErr != nil {
+		if productUUID == "" {
+			return "", false, serialErr
+		}
+		uuid, err := parseUUID(productUUID)
+		if err != nil {
+			return "", false, err
+		}
+		return uuid, false, nil
+	}
+	swapped := false
+	if pu, err := parseUUID(productUUID); err == nil && pu != uuid {
+		swapped = pu == swapUUIDByteOrder(uuid)
+	}
+	return uuid, swapped, nil
+}
+
+// readBIOSUUID returns the VM's BIOS UUID read from the DMI information
+// in dir.
+func readBIOSUUID(dir string) (string, error) {
+	uuid, _, err := biosUUID(
+		readDMI(dir, "product_serial"),
+		strings.ToLower(readDMI(dir, "product_uuid")))
+	return uuid, err
+}
+
+// parseVMwareSerial returns the UUID encoded in a VMware DMI serial.
+func parseVMwareSerial(serial string) (string, error) {
+	const prefix = "vmware-"
+	if !strings.HasPrefix(strings.ToLower(serial), prefix) {
+		return "", fmt.Errorf("invalid VMware serial: %q", serial)
+	}
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(
+		serial[len(prefix):])
+	uuid, err := formatUUID(digits)
+	if err != nil {
+		return "", fmt.Errorf("invalid VMware serial: %q", serial)
+	}
+	return uuid, nil
+}
+
+// parseUUID returns the UUID in its canonical, lower-case form.
+func parseUUID(s string) (string, error) {
+	uuid, err := formatUUID(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return "", fmt.Errorf("invalid UUID: %q", s)
+	}
+	return uuid, nil
+}
+
+// formatUUID formats 32 hexadecimal digits as a UUID.
+func formatUUID(digits string) (string, error) {
+	buf, err := hex.DecodeString(digits)
+	if err != nil || len(buf) != 16 {
+		return "", fmt.Errorf("invalid UUID: %q", digits)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x",
+		buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
+
+// swapUUIDByteOrder reverses the byte order of the first three fields of
+// the canonical UUID, converting between the big-endian and the
+// little-endian SMBIOS encodings.
+func swapUUIDByteOrder(uuid string) string {
+	buf, err := hex.DecodeString(strings.Replace(uuid, "-", "", -1))
+	if err != nil || len(buf) != 16 {
+		return uuid
+	}
+	for _, f := range [][2]int{{0, 4}, {4, 6}, {6, 8}} {
+		for i, j := f[0], f[1]-1; i < j; i, j = i+1, j-1 {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+	}
+	s, _ := formatUUID(hex.EncodeToString(buf))
+	return s
+}
@@ -0,0 +1,157 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+const (
+	// testSerial is the DMI serial of a VM with the BIOS UUID testUUID.
+	testSerial = "VMware-42 1e 6a 7b 1c 2d 3e 4f-5a 6b 7c 8d 9e 0f 1a 2b"
+	testUUID   = "421e6a7b-1c2d-3e4f-5a6b-7c8d9e0f1a2b"
+
+	// testSwappedUUID is testUUID with the byte order of its first three
+	// fields reversed.
+	testSwappedUUID = "7b6a1e42-2d1c-4f3e-5a6b-7c8d9e0f1a2b"
+)
+
+func TestParseVMwareSerial(t *testing.T) {
+	testCases := []struct {
+		serial  string
+		want    string
+		wantErr bool
+	}{
+		{serial: testSerial, want: testUUID},
+		{serial: "vmware-421e6a7b1c2d3e4f5a6b7c8d9e0f1a2b", want: testUUID},
+		{serial: "VMware-42 1E 6A 7B 1C 2D 3E 4F-5A 6B 7C 8D 9E 0F 1A 2B",
+			want: testUUID},
+		{serial: "", wantErr: true},
+		{serial: "Parallels-42 1e 6a 7b", wantErr: true},
+		{serial: "VMware-42 1e 6a 7b", wantErr: true},
+		{serial: "VMware-42 1e 6a 7b 1c 2d 3e 4f-5a 6b 7c 8d 9e 0f 1a zz",
+			wantErr: true},
+		{serial: "VMware-42 1e 6a 7b 1c 2d 3e 4f-5a 6b 7c 8d 9e 0f 1a 2b 3c",
+			wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.serial, func(t *testing.T) {
+			uuid, err := parseVMwareSerial(tc.serial)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if uuid != tc.want {
+				t.Errorf("parseVMwareSerial(%q) = %q, want %q",
+					tc.serial, uuid, tc.want)
+			}
+		})
+	}
+}
+
+func TestSwapUUIDByteOrder(t *testing.T) {
+	testCases := []struct {
+		uuid string
+		want string
+	}{
+		{uuid: testUUID, want: testSwappedUUID},
+		{uuid: testSwappedUUID, want: testUUID},
+		{
+			uuid: "00000000-0000-0000-0000-000000000000",
+			want: "00000000-0000-0000-0000-000000000000",
+		},
+		{uuid: "invalid", want: "invalid"},
+		{uuid: "421e6a7b-1c2d", want: "421e6a7b-1c2d"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.uuid, func(t *testing.T) {
+			if got := swapUUIDByteOrder(tc.uuid); got != tc.want {
+				t.Errorf("swapUUIDByteOrder(%q) = %q, want %q",
+					tc.uuid, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBiosUUID(t *testing.T) {
+	testCases := []struct {
+		name        string
+		serial      string
+		productUUID string
+		want        string
+		wantSwapped bool
+		wantErr     bool
+	}{
+		{
+			name:        "serial and matching product UUID",
+			serial:      testSerial,
+			productUUID: testUUID,
+			want:        testUUID,
+		},
+		{
+			name:        "serial and swapped product UUID",
+			serial:      testSerial,
+			productUUID: testSwappedUUID,
+			want:        testUUID,
+			wantSwapped: true,
+		},
+		{
+			name:        "serial and unrelated product UUID",
+			serial:      testSerial,
+			productUUID: "11111111-2222-3333-4444-555555555555",
+			want:        testUUID,
+		},
+		{
+			name:   "serial only",
+			serial: testSerial,
+			want:   testUUID,
+		},
+		{
+			name:        "product UUID only",
+			productUUID: "421E6A7B-1C2D-3E4F-5A6B-7C8D9E0F1A2B",
+			want:        testUUID,
+		},
+		{
+			name:        "not a VMware serial",
+			serial:      "0000-0000",
+			productUUID: testSwappedUUID,
+			want:        testSwappedUUID,
+		},
+		{
+			name:    "invalid serial only",
+			serial:  "0000-0000",
+			wantErr: true,
+		},
+		{
+			name:        "invalid product UUID",
+			productUUID: "invalid",
+			wantErr:     true,
+		},
+		{
+			name:    "neither",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			uuid, swapped, err := biosUUID(tc.serial, tc.productUUID)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if uuid != tc.want || swapped != tc.wantSwapped {
+				t.Errorf("biosUUID(%q, %q) = %q, %v, want %q, %v",
+					tc.serial, tc.productUUID,
+					uuid, swapped, tc.want, tc.wantSwapped)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+// pemBlockRx matches a PEM block whose line breaks may have been removed
+// or replaced with spaces, ex. when the block was stored in guestinfo.
+var pemBlockRx = regexp.MustCompile(
+	`-----BEGIN ([A-Z0-9 ]+)-----([\s\S]*?)-----END ([A-Z0-9 ]+)-----`)
+
+// renderFuncs returns the functions available to a template. The lookup
+// functions trim trailing newlines like the shell's command substitution,
+// so a template renders the same values a script reads with "rpc_get".
+func renderFuncs(config Backend, r *resolver) template.FuncMap {
+	var ovfEnv *ovf.Env
+	return template.FuncMap{
+		"guestinfo": func(key string) (string, error) {
+			val, err := config.String(guestinfoKey(key), "")
+			if err != nil {
+				return "", fmt.Errorf("failed to get %s: %v", key, err)
+			}
+			return trimValue(val), nil
+		},
+		"ovf": func(key string) (string, error) {
+			if ovfEnv == nil {
+				var err error
+				if ovfEnv, err = getOvfEnvIfSet(config); err != nil {
+					return "", err
+				}
+			}
+			val, err := getDecodedOvfEnvProperty(ovfEnv, key)
+			if err != nil {
+				return "", err
+			}
+			if vb, ok := config.(*valueBackend); ok {
+				if val, err = vb.open(val); err != nil {
+					return "", fmt.Errorf("failed to open %s: %v", key, err)
+				}
+			}
+			return trimValue(val), nil
+		},
+		"resolve": func(key string) (string, error) {
+			rv, err := r.resolve(key)
+			if err != nil {
+				return "", err
+			}
+			return trimValue(rv.Value), nil
+		},
+		"env": os.Getenv,
+		"default": func(def, val string) string {
+			if val == "" {
+				return def
+			}
+			return val
+		},
+		"required": func(msg, val string) (string, error) {
+			if val == "" {
+				return "", errors.New(msg)
+			}
+			return val, nil
+		},
+		"shq":   shellQuote,
+		"yamlq": yamlQuote,
+		"json": func(v interface{}) (string, error) {
+			// Unlike json.Marshal, <, >, and & are not escaped, since the
+			// result is written to configuration files, not HTML.
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(buf.String(), "\n"), nil
+		},
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			buf, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return "", fmt.Errorf("invalid base64 value: %v", err)
+			}
+			return string(buf), nil
+		},
+		"pem": formatPEM,
+		"indent": func(n int, s string) string {
+			lines := strings.Split(s, "\n")
+			for i, l := range lines {
+				if l != "" {
+					lines[i] = strings.Repeat(" ", n) + l
+				}
+			}
+			return strings.Join(lines, "\n")
+		},
+	}
+}
+
+// trimValue returns the value without trailing newlines, or an empty
+// string if the value is unset.
+func trimValue(val string) string {
+	if isUnset(val) {
+		return ""
+	}
+	return strings.TrimRight(val, "\n")
+}
+
+// formatPEM returns the PEM blocks in s with their line breaks restored:
+// the base64 body of each block is wrapped at 64 columns between its
+// BEGIN and END lines. The RFC 1421 headers of a block, ex. the
+// Proc-Type and DEK-Info of an encrypted key, must still be on lines of
+// their own, since they cannot be told apart once their line breaks are
+// removed.
+func formatPEM(s string) (string, error) {
+	var buf bytes.Buffer
+	for _, m := range pemBlockRx.FindAllStringSubmatch(s, -1) {
+		if m[1] != m[3] {
+			return "", fmt.Errorf("invalid PEM block: %s", m[1])
+		}
+		headers, body, err := splitPEMHeaders(m[2])
+		if err != nil {
+			return "", fmt.Errorf("invalid PEM block: %s: %v", m[1], err)
+		}
+		body = strings.Join(strings.Fields(body), "")
+		fmt.Fprintf(&buf, "-----BEGIN %s-----\n", m[1])
+		if len(headers) > 0 {
+			fmt.Fprintf(&buf, "%s\n\n", strings.Join(headers, "\n"))
+		}
+		for len(body) > 64 {
+			fmt.Fprintln(&buf, body[:64])
+			body = body[64:]
+		}
+		if body != "" {
+			fmt.Fprintln(&buf, body)
+		}
+		fmt.Fprintf(&buf, "-----END %s-----\n", m[3])
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("no PEM block found")
+	}
+	return buf.String(), nil
+}
+
+// splitPEMHeaders returns the header lines and the base64 body of the
+// content between a PEM block's BEGIN and END lines. The headers end at
+// the first blank line. A header may be continued on lines that start
+// with whitespace.
+func splitPEMHeaders(content string) ([]string, string, error) {
+	// Base64 does not use a colon, so a block without one has no headers.
+	if !strings.Contains(content, ":") {
+		return nil, content, nil
+	}
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	lines := strings.Split(strings.TrimLeft(content, " \t\n"), "\n")
+	var headers []string
+	for i, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		switch {
+		case l == "":
+			if len(headers) == 0 || i == len(lines)-1 {
+				return nil, "", fmt.Errorf("no body after the headers")
+			}
+			return headers, strings.Join(lines[i+1:], "\n"), nil
+		case l[0] == ' ' || l[0] == '\t':
+			if len(headers) == 0 {
+				return nil, "", fmt.Errorf("invalid header: %s", l)
+			}
+			headers[len(headers)-1] += "\n" + l
+		case strings.Count(l, ":") == 1 && strings.Index(l, ":") > 0:
+			headers = append(headers, l)
+		default:
+			// Ex. "Proc-Type: 4,ENCRYPTED DEK-Info: ..." or a header
+			// followed by the body without a blank line.
+			return nil, "", fmt.Errorf(
+				"the headers are not on lines of their own: %s", l)
+		}
+	}
+	return nil, "", fmt.Errorf("no body after the headers")
+}
+
+// parseOwner returns the user and group IDs of OWNER, which may be a
+// USER or USER:GROUP of names or IDs. A group of -1 is not changed.
+func parseOwner(owner string) (int, int, error) {
+	name, group := owner, ""
+	if i := strings.IndexByte(owner, ':'); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+	uid, err := strconv.Atoi(name)
+	if err != nil {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid owner: %v", err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	gid := -1
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid owner: %v", err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// render executes a text/template with the guestinfo and OVF lookup
+// functions and writes the result to stdout or atomically to a file.
+func render(config Backend, args []string) error {
+	fs := newFlagSet("render")
+	rf := addResolverFlags(fs)
+	in := fs.String(
+		"in", "-",
+		"The template. If \"-\" then the template is read from stdin.")
+	out := fs.String(
+		"out", "-",
+		"The file to which the result is written. If \"-\" then the "+
+			"result is written to stdout.")
+	modeFlag := fs.String(
+		"mode", "0644",
+		"The octal mode of the file.")
+	owner := fs.String(
+		"owner", "",
+		"The USER or USER:GROUP that owns the file.")
+	df := addDecryptFlags(fs)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments for render")
+	}
+	df.apply(config)
+	mode, err := strconv.ParseUint(*modeFlag, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid mode: %s", *modeFlag)
+	}
+	uid, gid := -1, -1
+	if *owner != "" {
+		if uid, gid, err = parseOwner(*owner); err != nil {
+			return err
+		}
+	}
+
+	var (
+		name = "stdin"
+		buf  []byte
+	)
+	if *in == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		name = filepath.Base(*in)
+		buf, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read template: %v", err)
+	}
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(name).Funcs(
+		renderFuncs(config, r)).Parse(string(buf))
+	if err != nil {
+		return err
+	}
+
+	var result bytes.Buffer
+	if err := batch(config, func() error {
+		return tmpl.Execute(&result, nil)
+	}); err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(result.Bytes())
+		return err
+	}
+	return writeFileAtomicOwner(
+		*out, result.Bytes(), os.FileMode(mode), uid, gid)
+}
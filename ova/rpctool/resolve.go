@@ -0,0 +1,310 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+const (
+	// sourceOvfEnv is the source of a value read from the OVF environment.
+	sourceOvfEnv = "guestinfo.ovfEnv"
+
+	// sourceDefault is the source of a value read from the defaults.
+	sourceDefault = "default"
+)
+
+// resolvedValue is a value and the layer from which it was read. An
+// empty Source means the value is unset.
+type resolvedValue struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// resolver implements the sk8 layered lookup:
+//
+//  1. guestinfo.NAMESPACE.KEY
+//  2. the OVF environment property KEY
+//  3. KEY in the NAMESPACE map of the cloud-init metadata in
+//     guestinfo.metadata
+//  4. the default value for KEY
+//
+// A value that is empty or the literal string "null" is treated as
+// unset and the lookup falls through to the next layer.
+type resolver struct {
+	config    Backend
+	namespace string
+	defaults  map[string]string
+
+	// ovfEnv is read the first time it is needed.
+	ovfEnv *ovf.Env
+
+	// metadata is read the first time it is needed.
+	metadata map[string]string
+}
+
+// guestinfoKey returns the guestinfo key for KEY in the namespace.
+func (r *resolver) guestinfoKey(key string) string {
+	if r.namespace == "" {
+		return guestinfoKey(key)
+	}
+	return guestinfoKey(r.namespace + "." + key)
+}
+
+func (r *resolver) resolve(key string) (resolvedValue, error) {
+	gkey := r.guestinfoKey(key)
+	val, err := r.config.String(gkey, "")
+	if err != nil {
+		return resolvedValue{}, fmt.Errorf("failed to get %s: %v", gkey, err)
+	}
+	if !isUnset(val) {
+		return resolvedValue{Value: val, Source: gkey}, nil
+	}
+
+	if r.ovfEnv == nil {
+		if r.ovfEnv, err = getOvfEnvIfSet(r.config); err != nil {
+			return resolvedValue{}, err
+		}
+	}
+	val, err = getDecodedOvfEnvProperty(r.ovfEnv, key)
+	if err != nil {
+		return resolvedValue{}, err
+	}
+	if vb, ok := r.config.(*valueBackend); ok {
+		if val, err = vb.open(val); err != nil {
+			return resolvedValue{}, fmt.Errorf("failed to open %s: %v", key, err)
+		}
+	}
+	if !isUnset(val) {
+		return resolvedValue{Value: val, Source: sourceOvfEnv}, nil
+	}
+
+	if r.metadata == nil {
+		if r.metadata, err = r.readMetadata(); err != nil {
+			return resolvedValue{}, err
+		}
+	}
+	val = r.metadata[key]
+	for k, v := range r.metadata {
+		if val == "" && strings.EqualFold(k, key) {
+			val = v
+		}
+	}
+	if vb, ok := r.config.(*valueBackend); ok {
+		if val, err = vb.open(val); err != nil {
+			return resolvedValue{}, fmt.Errorf("failed to open %s: %v", key, err)
+		}
+	}
+	if !isUnset(val) {
+		return resolvedValue{Value: val, Source: sourceMetadata}, nil
+	}
+
+	if val := r.defaults[key]; !isUnset(val) {
+		return resolvedValue{Value: val, Source: sourceDefault}, nil
+	}
+
+	return resolvedValue{}, nil
+}
+
+// readMetadata returns the values in the namespace's map of the
+// cloud-init metadata, or an empty map if guestinfo.metadata is not set.
+func (r *resolver) readMetadata() (map[string]string, error) {
+	doc, err := r.config.String(metadataKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", metadataKey, err)
+	}
+	if strings.TrimSpace(doc) == "" {
+		return map[string]string{}, nil
+	}
+	vals, err := parseMetadata(doc, r.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", metadataKey, err)
+	}
+	return vals, nil
+}
+
+// isUnset returns true if the value is empty or the string "null". Like
+// the shell's command substitution, trailing newlines are ignored.
+func isUnset(val string) bool {
+	val = strings.TrimRight(val, "\n")
+	return val == "" || val == "null"
+}
+
+// keyValFlag is a flag.Value that collects repeated KEY=VAL flags.
+type keyValFlag map[string]string
+
+func (f keyValFlag) String() string {
+	return ""
+}
+
+func (f keyValFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return fmt.Errorf("invalid KEY=VAL: %s", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+// resolverFlags are the flags used to configure a resolver.
+type resolverFlags struct {
+	namespace    *string
+	defaults     keyValFlag
+	defaultsFile *string
+}
+
+// addResolverFlags registers the -namespace, -default, and -defaults
+// flags with the flag set.
+func addResolverFlags(fs *flag.FlagSet) *resolverFlags {
+	f := &resolverFlags{defaults: keyValFlag{}}
+	f.namespace = fs.String(
+		"namespace", "sk8",
+		"The guestinfo namespace checked before the OVF environment, "+
+			"and the name of the map read from guestinfo.metadata.")
+	fs.Var(
+		f.defaults, "default",
+		"A KEY=VAL default used when KEY is otherwise unset. "+
+			"May be specified more than once.")
+	f.defaultsFile = fs.String(
+		"defaults", "",
+		"A JSON or KEY=VAL file of defaults. Values from -default "+
+			"take precedence.")
+	return f
+}
+
+// newResolver returns a resolver configured by the flags.
+func (f *resolverFlags) newResolver(config Backend) (*resolver, error) {
+	defaults := map[string]string{}
+	if *f.defaultsFile != "" {
+		buf, err := ioutil.ReadFile(*f.defaultsFile)
+		if err != nil {
+			return nil, err
+		}
+		_, vals, err := parseKeyValDoc(*f.defaultsFile, "", buf)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vals {
+			defaults[k] = v
+		}
+	}
+	for k, v := range f.defaults {
+		defaults[k] = v
+	}
+	return &resolver{
+		config:    config,
+		namespace: strings.Trim(*f.namespace, "."),
+		defaults:  defaults,
+	}, nil
+}
+
+// resolve prints the values of the keys using the sk8 layered lookup.
+func resolve(config Backend, args []string) error {
+	fs := newFlagSet("resolve")
+	rf := addResolverFlags(fs)
+	explain := fs.Bool(
+		"explain", false,
+		"Report the layer that supplied each value.")
+	format := fs.String(
+		"format", "text",
+		"The format of the output: \"text\", \"json\", or \"env\".")
+	addShowSecretsFlag(fs)
+	df := addDecryptFlags(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("invalid number of arguments for resolve")
+	}
+	df.apply(config)
+	if *format, err = parseChoice(
+		"format", *format, "text", "json", "env"); err != nil {
+		return err
+	}
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+
+	vals := map[string]resolvedValue{}
+	if err := batch(config, func() error {
+		for _, k := range keys {
+			rv, err := r.resolve(k)
+			if err != nil {
+				return err
+			}
+			vals[k] = rv
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Values are redacted in the structured formats, but not in the text
+	// format used by scripts to read a value.
+	strs := map[string]string{}
+	for k, rv := range vals {
+		if *format != "text" {
+			rv.Value = redact(k, rv.Value)
+			vals[k] = rv
+		}
+		strs[k] = rv.Value
+	}
+
+	var buf bytes.Buffer
+	switch *format {
+	case "text":
+		for _, k := range keys {
+			if *explain {
+				fmt.Fprintf(os.Stderr, "%s\t%s\n", k, sourceOrUnset(vals[k]))
+			}
+			// An unset key prints an empty line so the lines of the
+			// output correspond to the keys by position.
+			fmt.Fprintln(&buf, strings.TrimRight(vals[k].Value, "\n"))
+		}
+	case "json":
+		if *explain {
+			writeJSONObject(&buf, keys, func(k string) interface{} {
+				return vals[k]
+			})
+		} else {
+			writeKeyVals(&buf, *format, keys, strs)
+		}
+	case "env":
+		for _, k := range keys {
+			if *explain {
+				fmt.Fprintf(&buf, "# %s: %s\n", k, sourceOrUnset(vals[k]))
+			}
+			writeKeyVals(&buf, *format, []string{k}, strs)
+		}
+	}
+	_, err = os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+func sourceOrUnset(rv resolvedValue) string {
+	if rv.Source == "" {
+		return "unset"
+	}
+	return rv.Source
+}
@@ -0,0 +1,136 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestResolver(t *testing.T) {
+	defer func(s ovfSources) { ovfEnvSources = s }(ovfEnvSources)
+	ovfEnvSources = ovfSources{{kind: "guestinfo"}}
+
+	// ovfEnv returns an OVF environment with the property A, if not nil.
+	ovfEnv := func(val *string) string {
+		doc := `<Environment ` +
+			`xmlns="http://schemas.dmtf.org/ovf/environment/1" ` +
+			`xmlns:oe="http://schemas.dmtf.org/ovf/environment/1">` +
+			`<PropertySection>`
+		if val != nil {
+			doc += `<Property oe:key="A" oe:value="` + *val + `"/>`
+		}
+		return doc + `</PropertySection></Environment>`
+	}
+	str := func(s string) *string { return &s }
+
+	testCases := []struct {
+		name      string
+		guestinfo string
+		ovf       *string
+		metadata  string
+		defaults  map[string]string
+		want      resolvedValue
+	}{
+		{
+			name:      "guestinfo",
+			guestinfo: "1",
+			ovf:       str("2"),
+			metadata:  `{"sk8": {"A": "3"}}`,
+			defaults:  map[string]string{"A": "4"},
+			want:      resolvedValue{Value: "1", Source: "guestinfo.sk8.A"},
+		},
+		{
+			name:      "guestinfo null",
+			guestinfo: "null",
+			ovf:       str("2"),
+			metadata:  `{"sk8": {"A": "3"}}`,
+			defaults:  map[string]string{"A": "4"},
+			want:      resolvedValue{Value: "2", Source: sourceOvfEnv},
+		},
+		{
+			name:      "guestinfo null with a trailing newline",
+			guestinfo: "null\n",
+			ovf:       str("2"),
+			want:      resolvedValue{Value: "2", Source: sourceOvfEnv},
+		},
+		{
+			name:     "OVF environment null",
+			ovf:      str("null"),
+			metadata: `{"sk8": {"A": "3"}}`,
+			defaults: map[string]string{"A": "4"},
+			want:     resolvedValue{Value: "3", Source: sourceMetadata},
+		},
+		{
+			name:     "OVF environment empty",
+			ovf:      str(""),
+			metadata: "sk8:\n  A: 3\n",
+			want:     resolvedValue{Value: "3", Source: sourceMetadata},
+		},
+		{
+			name:     "metadata key in another case",
+			metadata: `{"sk8": {"a": "3"}}`,
+			want:     resolvedValue{Value: "3", Source: sourceMetadata},
+		},
+		{
+			name:     "metadata in another namespace",
+			metadata: `{"other": {"A": "3"}}`,
+			defaults: map[string]string{"A": "4"},
+			want:     resolvedValue{Value: "4", Source: sourceDefault},
+		},
+		{
+			name:     "metadata null",
+			metadata: `{"sk8": {"A": null}}`,
+			defaults: map[string]string{"A": "4"},
+			want:     resolvedValue{Value: "4", Source: sourceDefault},
+		},
+		{
+			name:     "metadata string null",
+			metadata: `{"sk8": {"A": "null"}}`,
+			defaults: map[string]string{"A": "4"},
+			want:     resolvedValue{Value: "4", Source: sourceDefault},
+		},
+		{
+			name:     "default null",
+			defaults: map[string]string{"A": "null"},
+		},
+		{
+			name: "unset",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := newMemoryBackend()
+			if tc.guestinfo != "" {
+				mem.SetString("guestinfo.sk8.A", tc.guestinfo)
+			}
+			if tc.ovf != nil {
+				mem.SetString(ovfEnvGuestinfoKey, ovfEnv(tc.ovf))
+			}
+			if tc.metadata != "" {
+				mem.SetString(metadataKey, tc.metadata)
+			}
+			r := &resolver{
+				config:    &valueBackend{Backend: mem},
+				namespace: "sk8",
+				defaults:  tc.defaults,
+			}
+			got, err := r.resolve("A")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("resolve(A) = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
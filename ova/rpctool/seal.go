@@ -0,0 +1,391 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// sealedPrefix is the prefix of a sealed value. A sealed value is
+	// "rpctool.sealed:v1:KEYID:PAYLOAD" where KEYID identifies the public
+	// key the value was sealed to and PAYLOAD is the base64 encoding of
+	// the wrapped content key, the nonce, and the ciphertext.
+	sealedPrefix = "rpctool.sealed:v1:"
+
+	// sealLabel is the RSA-OAEP label used to wrap a content key.
+	sealLabel = "rpctool.sealed"
+
+	// defaultSealKeyFile is the path of the VM's private key.
+	defaultSealKeyFile = "/var/lib/rpctool/seal.key"
+
+	// defaultSealKeyBits is the size of a key created by keygen.
+	defaultSealKeyBits = 3072
+
+	// sealPublicKey is the guestinfo key to which keygen publishes the
+	// VM's public key.
+	sealPublicKey = "rpctool.seal.publicKey"
+
+	// sealKeyUUIDSuffix is the suffix of the file, next to the private
+	// key, in which keygen records the BIOS UUID of the VM that created
+	// the key.
+	sealKeyUUIDSuffix = ".uuid"
+)
+
+// isSealed returns true if the value was sealed with sealValue.
+func isSealed(val string) bool {
+	return strings.HasPrefix(val, sealedPrefix)
+}
+
+// sealKeyID returns the ID of the public key, the first eight bytes of
+// the SHA-256 checksum of the key's PKIX encoding.
+func sealKeyID(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// sealValue encrypts the value to the public key. The value is encrypted
+// with a random AES-256-GCM content key, and the content key is wrapped
+// with RSA-OAEP.
+func sealValue(pub *rsa.PublicKey, val string) (string, error) {
+	id, err := sealKeyID(pub)
+	if err != nil {
+		return "", err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	wrapped, err := rsa.EncryptOAEP(
+		sha256.New(), rand.Reader, pub, key, []byte(sealLabel))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload := append(wrapped, nonce...)
+	payload = gcm.Seal(payload, nonce, []byte(val), []byte(id))
+	return sealedPrefix + id + ":" +
+		base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// openValue decrypts a value sealed to the private key's public key.
+func openValue(priv *rsa.PrivateKey, val string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(val, sealedPrefix), ":", 2)
+	if !isSealed(val) || len(parts) != 2 {
+		return "", errors.New("invalid sealed value")
+	}
+	id, err := sealKeyID(&priv.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	if parts[0] != id {
+		return "", fmt.Errorf(
+			"value is sealed to key %s, not this VM's key %s", parts[0], id)
+	}
+	payload, err := base64.StdEncoding.DecodeString(strings.Join(
+		strings.Fields(parts[1]), ""))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed value: %v", err)
+	}
+	n := priv.Size()
+	if len(payload) < n {
+		return "", errors.New("invalid sealed value: too short")
+	}
+	key, err := rsa.DecryptOAEP(
+		sha256.New(), nil, priv, payload[:n], []byte(sealLabel))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap sealed value: %v", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	payload = payload[n:]
+	if len(payload) < gcm.NonceSize() {
+		return "", errors.New("invalid sealed value: too short")
+	}
+	buf, err := gcm.Open(
+		nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to open sealed value: %v", err)
+	}
+	return string(buf), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readSealPrivateKey reads a PEM-encoded RSA private key.
+func readSealPrivateKey(path string) (*rsa.PrivateKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid private key: %s", path)
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %s: %v", path, err)
+	}
+	return priv, nil
+}
+
+// parseSealPublicKey parses a PEM-encoded RSA public key.
+func parseSealPublicKey(buf []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(buf)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("invalid public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid public key: not an RSA key")
+	}
+	return pub, nil
+}
+
+// encodeSealPublicKey returns the PEM encoding of the public key.
+func encodeSealPublicKey(pub *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(
+		&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// decryptFlags are the flags used to open sealed values.
+type decryptFlags struct {
+	decrypt *bool
+	keyFile *string
+}
+
+// addDecryptFlags registers the -decrypt and -key flags with the flag set.
+func addDecryptFlags(fs *flag.FlagSet) *decryptFlags {
+	return &decryptFlags{
+		decrypt: fs.Bool(
+			"decrypt", false,
+			"Open sealed values with the VM's private key."),
+		keyFile: fs.String(
+			"key", defaultSealKeyFile,
+			"The VM's private key."),
+	}
+}
+
+// apply configures the backend to open sealed values if -decrypt was
+// specified. The private key is read when the first sealed value is.
+func (f *decryptFlags) apply(config Backend) {
+	if vb, ok := config.(*valueBackend); ok && *f.decrypt {
+		vb.keyFile = *f.keyFile
+	}
+}
+
+// keygen creates the VM's key pair, unless it already exists, and
+// publishes the public key to guestinfo. The BIOS UUID of the VM is
+// recorded with the key, and the key is replaced if the UUID changes, so
+// a clone of the VM does not share its key.
+func keygen(config Backend, args []string) error {
+	fs := newFlagSet("keygen")
+	keyFile := fs.String(
+		"key", defaultSealKeyFile,
+		"The VM's private key. The file is created with mode 0600.")
+	bits := fs.Int(
+		"bits", defaultSealKeyBits,
+		"The size of the key.")
+	force := fs.Bool(
+		"force", false,
+		"Replace an existing key. Values sealed to the old key can no "+
+			"longer be opened.")
+	publish := fs.String(
+		"publish", sealPublicKey,
+		"The guestinfo key to which the public key is published. The "+
+			"public key is not published if empty.")
+	dmiDir := fs.String(
+		"dmi", defaultDMIDir,
+		"The directory with the DMI product_serial and product_uuid.")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("invalid number of arguments for keygen")
+	}
+
+	// The UUID is empty if it cannot be read, ex. outside of a VM, in
+	// which case an existing key is kept.
+	uuid, _ := readBIOSUUID(*dmiDir)
+	uuidFile := *keyFile + sealKeyUUIDSuffix
+	keyUUID := ""
+	if buf, err := ioutil.ReadFile(uuidFile); err == nil {
+		keyUUID = strings.TrimSpace(string(buf))
+	}
+
+	priv, err := readSealPrivateKey(*keyFile)
+	create := *force
+	if err != nil {
+		if _, statErr := os.Stat(*keyFile); statErr == nil && !*force {
+			return err
+		}
+		create = true
+	} else if !create && uuid != "" && keyUUID != "" && keyUUID != uuid {
+		fmt.Fprintf(os.Stderr,
+			"the BIOS UUID changed from %s to %s, replacing the key\n",
+			keyUUID, uuid)
+		create = true
+	}
+	if create {
+		if priv, err = rsa.GenerateKey(rand.Reader, *bits); err != nil {
+			return fmt.Errorf("failed to generate key: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(*keyFile), 0700); err != nil {
+			return fmt.Errorf("failed to write private key: %v", err)
+		}
+		if err := writeFileAtomic(*keyFile, pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(priv),
+		}), 0600); err != nil {
+			return err
+		}
+	}
+	if uuid != "" && (create || keyUUID != uuid) {
+		if err := writeFileAtomic(
+			uuidFile, []byte(uuid+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	pub, err := encodeSealPublicKey(&priv.PublicKey)
+	if err != nil {
+		return err
+	}
+	if *publish != "" {
+		key := guestinfoKey(*publish)
+		if err := config.SetString(key, pub); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+	}
+	fmt.Print(pub)
+	return nil
+}
+
+// newSealFlagSet returns the flag set of the seal command and its -pubkey
+// and -guestinfo flags.
+func newSealFlagSet() (*flag.FlagSet, *string, *string) {
+	fs := newFlagSet("seal")
+	pubFile := fs.String(
+		"pubkey", "",
+		"A file with the VM's public key. The public key is read from "+
+			"guestinfo if omitted.")
+	pubKey := fs.String(
+		"guestinfo", sealPublicKey,
+		"The guestinfo key from which the public key is read.")
+	return fs, pubFile, pubKey
+}
+
+// sealReadsGuestinfo returns false if the seal arguments specify a public
+// key file, in which case the value may be sealed outside of a VM without
+// a backend. Invalid arguments are reported by seal.
+func sealReadsGuestinfo(args []string) bool {
+	fs, pubFile, _ := newSealFlagSet()
+	fs.SetOutput(ioutil.Discard)
+	if _, err := parseLeadingFlags(fs, args); err != nil {
+		return true
+	}
+	return *pubFile == ""
+}
+
+// seal prints the value sealed to a VM's public key. The public key is
+// read from a file, or from guestinfo, so values may be sealed on a host
+// without access to the VM. The config is not used, and may be nil, if
+// the public key is read from a file.
+func seal(config Backend, args []string) error {
+	fs, pubFile, pubKey := newSealFlagSet()
+	args, err := parseLeadingFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("invalid number of arguments for seal")
+	}
+	val := args[0]
+	if val == "-" {
+		if val, err = readStdin(); err != nil {
+			return err
+		}
+	}
+
+	var buf []byte
+	if *pubFile != "" {
+		if buf, err = ioutil.ReadFile(*pubFile); err != nil {
+			return fmt.Errorf("failed to read public key: %v", err)
+		}
+	} else {
+		key := guestinfoKey(*pubKey)
+		s, err := config.String(key, "")
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %v", key, err)
+		}
+		if s == "" {
+			return fmt.Errorf("%s is not set, has keygen been run?", key)
+		}
+		buf = []byte(s)
+	}
+	pub, err := parseSealPublicKey(buf)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealValue(pub, val)
+	if err != nil {
+		return fmt.Errorf("failed to seal value: %v", err)
+	}
+	fmt.Println(sealed)
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSealKey returns a new private key. The key is smaller than the
+// keys created by keygen so the tests are fast.
+func newTestSealKey(t *testing.T) *rsa.PrivateKey {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestSealValue(t *testing.T) {
+	priv := newTestSealKey(t)
+	for _, val := range []string{
+		"",
+		"secret",
+		"line 1\nline 2\n",
+		strings.Repeat("x", 64*1024),
+	} {
+		sealed, err := sealValue(&priv.PublicKey, val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isSealed(sealed) {
+			t.Fatalf("sealValue(%.16q) = %.32q, not sealed", val, sealed)
+		}
+		if val != "" && strings.Contains(sealed, val) {
+			t.Fatalf("sealValue(%.16q) contains the value", val)
+		}
+		got, err := openValue(priv, sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != val {
+			t.Errorf("openValue(sealValue(%.16q)) = %.16q", val, got)
+		}
+	}
+
+	// Sealing the same value twice uses a new content key and nonce.
+	a, _ := sealValue(&priv.PublicKey, "secret")
+	b, _ := sealValue(&priv.PublicKey, "secret")
+	if a == b {
+		t.Error("sealValue returned the same sealed value twice")
+	}
+}
+
+func TestOpenValueInvalid(t *testing.T) {
+	priv := newTestSealKey(t)
+	other := newTestSealKey(t)
+	sealed, err := sealValue(&priv.PublicKey, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := sealKeyID(&priv.PublicKey)
+	otherID, _ := sealKeyID(&other.PublicKey)
+	payload := strings.TrimPrefix(sealed, sealedPrefix+id+":")
+
+	// tamper flips a bit in the last byte of the ciphertext.
+	tamper := func(s string) string {
+		buf, _ := base64.StdEncoding.DecodeString(s)
+		buf[len(buf)-1] ^= 1
+		return base64.StdEncoding.EncodeToString(buf)
+	}
+
+	testCases := []struct {
+		name string
+		priv *rsa.PrivateKey
+		val  string
+	}{
+		{name: "wrong key", priv: other, val: sealed},
+		{
+			name: "wrong key with its key ID",
+			priv: other,
+			val:  sealedPrefix + otherID + ":" + payload,
+		},
+		{name: "not sealed", priv: priv, val: "secret"},
+		{name: "no key ID", priv: priv, val: sealedPrefix + payload},
+		{name: "invalid base64", priv: priv, val: sealedPrefix + id + ":!"},
+		{name: "too short", priv: priv, val: sealedPrefix + id + ":" +
+			base64.StdEncoding.EncodeToString([]byte("short"))},
+		{
+			name: "tampered ciphertext",
+			priv: priv,
+			val:  sealedPrefix + id + ":" + tamper(payload),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if val, err := openValue(tc.priv, tc.val); err == nil {
+				t.Errorf("openValue = %q, want error", val)
+			}
+		})
+	}
+}
+
+func TestSealPublicKey(t *testing.T) {
+	priv := newTestSealKey(t)
+	enc, err := encodeSealPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := parseSealPublicKey([]byte(enc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.N.Cmp(priv.N) != 0 || pub.E != priv.E {
+		t.Error("parseSealPublicKey returned a different key")
+	}
+	if _, err := parseSealPublicKey([]byte("invalid")); err == nil {
+		t.Error("parseSealPublicKey succeeded, want error")
+	}
+}
+
+func TestValueBackendOpen(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	priv := newTestSealKey(t)
+	keyFile := filepath.Join(dir, "seal.key")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(priv),
+	}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealValue(&priv.PublicKey, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		keyFile string
+		val     string
+		want    string
+		wantErr bool
+	}{
+		{name: "sealed", keyFile: keyFile, val: sealed, want: "secret"},
+		{name: "not sealed", keyFile: keyFile, val: "plain", want: "plain"},
+		{name: "without a key", val: sealed, want: sealed},
+		{
+			name:    "missing key",
+			keyFile: filepath.Join(dir, "missing.key"),
+			val:     sealed,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &valueBackend{Backend: newMemoryBackend(), keyFile: tc.keyFile}
+			val, err := b.open(tc.val)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if val != tc.want {
+				t.Errorf("open = %.16q, want %.16q", val, tc.want)
+			}
+		})
+	}
+}
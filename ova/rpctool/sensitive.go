@@ -0,0 +1,266 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+// redactedValue replaces the value of a sensitive key in listing output.
+const redactedValue = "[REDACTED]"
+
+// defaultSensitiveKeys are the patterns of the keys that hold credentials
+// and key material in a default sk8 deployment: the keys that the sk8 key
+// schema marks as sensitive, and the keys written by the sk8 scripts.
+var defaultSensitiveKeys = append(sensitiveKeys{
+	"*PASSWORD*",
+	"*SECRET*",
+	"*_TOKEN",
+	"*_PRV_KEY",
+	"KUBECONFIG",
+	"TLS_CA_PEM",
+}, schemaSensitiveKeys...)
+
+var (
+	// sensitive is the registry of sensitive keys set by the global
+	// flag -sensitive.
+	sensitive = defaultSensitiveKeys
+
+	// showSecrets is set by the global flag -show-secrets.
+	showSecrets bool
+)
+
+// sensitiveKeys is a list of case-insensitive path.Match patterns that
+// match the keys whose values should not be printed or retained. It
+// implements flag.Value as a comma-separated list.
+type sensitiveKeys []string
+
+func (s *sensitiveKeys) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *sensitiveKeys) Set(val string) error {
+	var patterns sensitiveKeys
+	for _, p := range strings.Split(val, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s", p)
+		}
+		patterns = append(patterns, strings.ToUpper(p))
+	}
+	*s = patterns
+	return nil
+}
+
+// chunkSuffixRx matches the suffix of the key of a chunk of a value.
+var chunkSuffixRx = regexp.MustCompile(`\.CHUNK\.[0-9]+$`)
+
+// match returns true if the key, or the last dot-separated segment of
+// the key, matches one of the patterns. For example, the pattern
+// "*PASSWORD*" matches both VSPHERE_PASSWORD and
+// guestinfo.sk8.VSPHERE_PASSWORD. The chunks and encoding sidecar of a
+// value match if the value's key does.
+func (s sensitiveKeys) match(key string) bool {
+	key = strings.ToUpper(key)
+	key = strings.TrimSuffix(key, strings.ToUpper(encodingSuffix))
+	key = chunkSuffixRx.ReplaceAllString(key, "")
+	base := key[strings.LastIndexByte(key, '.')+1:]
+	for _, p := range s {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addShowSecretsFlag registers the -show-secrets flag with the command's
+// flag set so it may be specified after the command as well as before it.
+func addShowSecretsFlag(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&showSecrets, "show-secrets", showSecrets,
+		"Print the values of sensitive keys instead of redacting them.")
+}
+
+// redact returns redactedValue if the key is sensitive and the value is
+// not empty, unless -show-secrets was specified.
+func redact(key, val string) string {
+	if showSecrets || val == "" || !sensitive.match(key) {
+		return val
+	}
+	return redactedValue
+}
+
+// errRedactedValue returns the error for an attempt to set a key that has
+// no stored value to the redactedValue placeholder.
+func errRedactedValue(key string) error {
+	return fmt.Errorf(
+		"refusing to set %s to %s: the key has no stored value",
+		key, redactedValue)
+}
+
+// setKeepingRedacted sets the guestinfo key to the value unless the value
+// is the redactedValue placeholder, in which case the stored value is
+// kept. This allows the output of "get-many" to be written back with
+// "set-many" without erasing the secrets it redacted.
+func setKeepingRedacted(config Backend, key, val string) error {
+	if val != redactedValue {
+		return config.SetString(key, val)
+	}
+	cur, err := config.String(key, "")
+	if err != nil {
+		return err
+	}
+	if isUnset(cur) {
+		return errRedactedValue(key)
+	}
+	return nil
+}
+
+// restoreRedactedOvfEnv returns the OVF environment document with the
+// properties whose values are the redactedValue placeholder set to their
+// values in the stored OVF environment. This allows the output of
+// "get.ovf" to be written back with "set.ovf" without erasing the
+// secrets it redacted.
+func restoreRedactedOvfEnv(config Backend, raw string) (string, error) {
+	doc, err := parseOvfEnvDoc(raw)
+	if err != nil {
+		return "", err
+	}
+	var cur *ovfEnvDoc
+	for _, k := range doc.keys() {
+		if v, _ := doc.get(k); v != redactedValue {
+			continue
+		}
+		if cur == nil {
+			if cur, err = getOvfEnvDocIfSet(config); err != nil {
+				return "", err
+			}
+		}
+		v, ok := "", false
+		if cur != nil {
+			v, ok = cur.get(k)
+		}
+		if !ok {
+			return "", errRedactedValue(k)
+		}
+		doc.set(k, v)
+	}
+	return doc.String(), nil
+}
+
+// redactOvfEnv returns a copy of the OVF environment with the values of
+// the sensitive properties redacted.
+func redactOvfEnv(ovfEnv *ovf.Env) *ovf.Env {
+	if ovfEnv.Property == nil {
+		return ovfEnv
+	}
+	env := *ovfEnv
+	env.Property = &ovf.PropertySection{
+		Properties: make([]ovf.EnvProperty, len(ovfEnv.Property.Properties)),
+	}
+	for i, p := range ovfEnv.Property.Properties {
+		env.Property.Properties[i] = ovf.EnvProperty{
+			Key:   p.Key,
+			Value: redact(p.Key, p.Value),
+		}
+	}
+	return &env
+}
+
+// scrub blanks the sensitive keys in guestinfo and removes them from the
+// OVF environment. The keys considered are the arguments, the keys in
+// the manifest, the properties in the OVF environment, and the keys that
+// the sk8 key schema marks as sensitive.
+func scrub(config *valueBackend, args []string) error {
+	fs := newFlagSet("scrub")
+	namespace := fs.String(
+		"namespace", "sk8",
+		"The guestinfo namespace of the keys.")
+	manifest := fs.String(
+		"manifest", "",
+		"A file with additional keys to consider, one per line.")
+	dryRun := fs.Bool(
+		"dry-run", false,
+		"Print the keys that would be scrubbed without scrubbing them.")
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *manifest != "" {
+		buf, err := ioutil.ReadFile(*manifest)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %v", err)
+		}
+		keys = append(keys, parseKeyList(string(buf))...)
+	}
+	keys = append(keys, schemaSensitiveKeys...)
+	r := &resolver{namespace: strings.Trim(*namespace, ".")}
+
+	return batch(config, func() error {
+		doc, err := getOvfEnvDocIfSet(config)
+		if err != nil {
+			return err
+		}
+		if doc != nil {
+			keys = append(keys, doc.keys()...)
+		}
+
+		// Blank the sensitive keys in guestinfo.
+		scrubbed := map[string]bool{}
+		for _, k := range uniqueStrings(keys) {
+			if strings.HasSuffix(k, encodingSuffix) || !sensitive.match(k) {
+				continue
+			}
+			scrubbed[strings.ToUpper(k)] = true
+			gkey := r.guestinfoKey(k)
+			fmt.Fprintf(os.Stderr, "scrub %s\n", gkey)
+			if *dryRun {
+				continue
+			}
+			if err := config.clear(gkey); err != nil {
+				return fmt.Errorf("failed to scrub %s: %v", gkey, err)
+			}
+		}
+
+		// Remove the sensitive properties, and their encoding properties,
+		// from the OVF environment.
+		if doc == nil {
+			return nil
+		}
+		for _, k := range doc.keys() {
+			if scrubbed[strings.ToUpper(strings.TrimSuffix(k, encodingSuffix))] {
+				fmt.Fprintf(os.Stderr, "scrub %s.%s\n", sourceOvfEnv, k)
+				doc.remove(k)
+			}
+		}
+		if *dryRun {
+			return nil
+		}
+		return setOvfEnvDoc(config, doc)
+	})
+}
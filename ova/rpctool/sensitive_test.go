@@ -0,0 +1,112 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestSensitiveKeysMatch(t *testing.T) {
+	testCases := []struct {
+		key  string
+		want bool
+	}{
+		{key: "VSPHERE_PASSWORD", want: true},
+		{key: "vsphere_password", want: true},
+		{key: "guestinfo.sk8.VSPHERE_PASSWORD", want: true},
+		{key: "sk8.AWS_SECRET_ACCESS_KEY", want: true},
+		{key: "sk8.SSH_PRV_KEY", want: true},
+		{key: "sk8.SSH_PRV_KEY.chunk.0", want: true},
+		{key: "guestinfo.sk8.SSH_PRV_KEY.chunk.12", want: true},
+		{key: "sk8.SSH_PRV_KEY.encoding", want: true},
+		{key: "sk8.SSH_PRV_KEY.ENCODING", want: true},
+		{key: "sk8.KUBECONFIG", want: true},
+		{key: "sk8.ENCRYPTION_KEY", want: true},
+		{key: "sk8.SSH_PUB_KEY"},
+		{key: "sk8.SSH_PUB_KEY.chunk.0"},
+		{key: "sk8.KUBECONFIG_PATH"},
+		{key: "sk8.NUM_NODES"},
+		{key: "sk8.NUM_NODES.encoding"},
+		{key: "sk8.chunk.0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			if got := defaultSensitiveKeys.match(tc.key); got != tc.want {
+				t.Errorf("match(%q) = %v, want %v", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSensitiveKeysSet(t *testing.T) {
+	testCases := []struct {
+		val     string
+		key     string
+		want    bool
+		wantErr bool
+	}{
+		{val: "*_token", key: "sk8.GITHUB_TOKEN", want: true},
+		{val: " A , B ", key: "sk8.B", want: true},
+		{val: "A,,B", key: "sk8.C"},
+		{val: "SK8.*", key: "sk8.NUM_NODES", want: true},
+		{val: "[", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.val, func(t *testing.T) {
+			var s sensitiveKeys
+			err := s.Set(tc.val)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v",
+					tc.val, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if got := s.match(tc.key); got != tc.want {
+				t.Errorf("match(%q) = %v, want %v", tc.key, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetKeepingRedacted(t *testing.T) {
+	testCases := []struct {
+		name    string
+		stored  string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "new value", stored: "secret", value: "new", want: "new"},
+		{name: "placeholder", stored: "secret", value: redactedValue,
+			want: "secret"},
+		{name: "placeholder without stored value", value: redactedValue,
+			wantErr: true},
+		{name: "empty value", stored: "secret", value: "", want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newMemoryBackend()
+			if tc.stored != "" {
+				b.SetString("sk8.VSPHERE_PASSWORD", tc.stored)
+			}
+			err := setKeepingRedacted(b, "sk8.VSPHERE_PASSWORD", tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if val, _ := b.String("sk8.VSPHERE_PASSWORD", ""); val != tc.want {
+				t.Errorf("stored value = %q, want %q", val, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// defaultServeListen is the address on which "serve" listens.
+	defaultServeListen = "unix:///run/sk8/guestinfo.sock"
+
+	// ovfKeyPrefix qualifies the key of an OVF environment property when
+	// it is matched against the -read and -write patterns.
+	ovfKeyPrefix = "ovfEnv."
+
+	// maxServeBody is the largest request body that is read.
+	maxServeBody = 4 << 20
+)
+
+// keyPatterns is a list of case-insensitive path.Match patterns that
+// match whole keys. It implements flag.Value as a comma-separated list.
+type keyPatterns []string
+
+func (p *keyPatterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *keyPatterns) Set(val string) error {
+	var patterns keyPatterns
+	for _, s := range strings.Split(val, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, err := path.Match(s, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s", s)
+		}
+		patterns = append(patterns, strings.ToUpper(s))
+	}
+	*p = patterns
+	return nil
+}
+
+func (p keyPatterns) match(key string) bool {
+	key = strings.ToUpper(key)
+	for _, s := range p {
+		if ok, _ := path.Match(s, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// peerListener is a net.Listener that records the user ID of the peer of
+// each unix socket connection, so the requests may be authorized by it.
+type peerListener struct {
+	net.Listener
+}
+
+func (l peerListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := c.(*net.UnixConn); ok {
+		if uid, err := peerUID(uc); err == nil {
+			return peerConn{Conn: c, uid: uid}, nil
+		}
+	}
+	return c, nil
+}
+
+// peerConn is a connection whose peer has the user ID uid. Its local
+// address is a peerAddr, which the HTTP server stores in the context of
+// each request with the key http.LocalAddrContextKey.
+type peerConn struct {
+	net.Conn
+	uid int
+}
+
+func (c peerConn) LocalAddr() net.Addr {
+	return peerAddr{Addr: c.Conn.LocalAddr(), uid: c.uid}
+}
+
+// peerAddr is the local address of a peerConn.
+type peerAddr struct {
+	net.Addr
+	uid int
+}
+
+// guestinfoServer serves the guestinfo keys and the OVF environment.
+type guestinfoServer struct {
+	config Backend
+	read   keyPatterns
+	write  keyPatterns
+	token  string
+
+	// uids are the users allowed to connect to a unix socket without a
+	// token. If anyUID is true then every local user is allowed.
+	uids   map[int]bool
+	anyUID bool
+
+	// mu serializes access to the backend.
+	mu sync.Mutex
+}
+
+// serve runs the guestinfo API server until it is interrupted.
+func serve(config Backend, args []string) error {
+	fs := newFlagSet("serve")
+	listen := fs.String(
+		"listen", defaultServeListen,
+		"The address to listen on, \"unix://PATH\" or \"tcp://HOST:PORT\".")
+	s := &guestinfoServer{
+		config: config,
+		read:   keyPatterns{"SK8.*", "OVFENV.*"},
+		uids:   map[int]bool{0: true, os.Getuid(): true},
+	}
+	fs.Var(
+		&s.read, "read",
+		"A comma-separated list of case-insensitive patterns that match "+
+			"the keys that may be read. OVF environment properties are "+
+			"matched as ovfEnv.KEY.")
+	fs.Var(
+		&s.write, "write",
+		"A comma-separated list of case-insensitive patterns that match "+
+			"the keys that may be written. No keys may be written by "+
+			"default.")
+	tokenFile := fs.String(
+		"token", "",
+		"A file with a bearer token that authorizes a request.")
+	allowUIDs := fs.String(
+		"allow-uid", "0",
+		"A comma-separated list of the user IDs that may use the unix "+
+			"socket without a token, or \"*\" for every user. The user "+
+			"that runs the server is always allowed.")
+	addShowSecretsFlag(fs)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("invalid number of arguments for serve")
+	}
+	for _, v := range strings.Split(*allowUIDs, ",") {
+		if v = strings.TrimSpace(v); v == "*" {
+			s.anyUID = true
+		} else if v != "" {
+			uid, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid allow-uid: %s", v)
+			}
+			s.uids[uid] = true
+		}
+	}
+	if *tokenFile != "" {
+		buf, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read token: %v", err)
+		}
+		if s.token = strings.TrimSpace(string(buf)); s.token == "" {
+			return fmt.Errorf("token file %s is empty", *tokenFile)
+		}
+	}
+
+	network, addr := "tcp", *listen
+	if strings.HasPrefix(addr, "unix:") {
+		network = "unix"
+		addr = strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+	} else {
+		addr = strings.TrimPrefix(addr, "tcp://")
+	}
+	if network == "tcp" && s.token == "" {
+		return fmt.Errorf("listening on tcp requires -token")
+	}
+	if network == "unix" {
+		if err := os.MkdirAll(filepath.Dir(addr), 0755); err != nil {
+			return err
+		}
+		// Remove the socket left behind by a previous server.
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		// Every user may connect. Requests are authorized by the peer's
+		// user ID or the token.
+		if err := os.Chmod(addr, 0666); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	srv := &http.Server{Handler: s}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		srv.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "listening on %s://%s\n", network, addr)
+	if err := srv.Serve(peerListener{l}); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// authorized returns true if the request has the token or was sent over
+// a unix socket by an allowed user.
+func (s *guestinfoServer) authorized(r *http.Request) bool {
+	if s.token != "" {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && subtle.ConstantTimeCompare(
+			[]byte(auth[len("Bearer "):]), []byte(s.token)) == 1 {
+			return true
+		}
+	}
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(peerAddr)
+	return ok && (s.anyUID || s.uids[addr.uid])
+}
+
+// ServeHTTP serves the API:
+//
+//	GET /v1/keys/KEY  the value of guestinfo.KEY
+//	PUT /v1/keys/KEY  sets guestinfo.KEY to the "value" in the body
+//	GET /v1/ovf       the OVF environment in the compact JSON schema
+//	GET /v1/ovf/KEY   the value of the OVF environment property KEY
+//	PUT /v1/ovf/KEY   sets the OVF environment property KEY
+func (s *guestinfoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, body := s.handle(r)
+	fmt.Fprintf(os.Stderr, "%s %s %d\n", r.Method, r.URL.Path, status)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(body)
+}
+
+// serveValue is the body of a key's GET response and PUT request.
+type serveValue struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+// serveError is the body of an error response.
+type serveError struct {
+	Error string `json:"error"`
+}
+
+// handle returns the status and body of the response to the request.
+func (s *guestinfoServer) handle(r *http.Request) (int, interface{}) {
+	if !s.authorized(r) {
+		return http.StatusUnauthorized, serveError{"unauthorized"}
+	}
+	var kind, key string
+	switch p := r.URL.Path; {
+	case strings.HasPrefix(p, "/v1/keys/"):
+		kind, key = "keys", strings.TrimPrefix(p, "/v1/keys/")
+		key = strings.TrimPrefix(key, guestinfoPrefix)
+	case p == "/v1/ovf" && r.Method == http.MethodGet:
+		return s.getOvf()
+	case strings.HasPrefix(p, "/v1/ovf/"):
+		kind, key = "ovf", strings.TrimPrefix(p, "/v1/ovf/")
+	default:
+		return http.StatusNotFound, serveError{"not found"}
+	}
+	if key == "" || strings.Contains(key, "/") {
+		return http.StatusNotFound, serveError{"not found"}
+	}
+	pattern := key
+	if kind == "ovf" {
+		pattern = ovfKeyPrefix + key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		if !s.read.match(pattern) {
+			return http.StatusForbidden, serveError{"read not allowed: " + key}
+		}
+		var val string
+		var err error
+		if kind == "ovf" {
+			val, err = getValueInOvfEnv(key, s.config)
+		} else {
+			val, err = s.config.String(guestinfoKey(key), "")
+		}
+		if err != nil {
+			return http.StatusInternalServerError, serveError{err.Error()}
+		}
+		return http.StatusOK, serveValue{Key: key, Value: redact(key, val)}
+	case http.MethodPut:
+		if !s.write.match(pattern) {
+			return http.StatusForbidden, serveError{"write not allowed: " + key}
+		}
+		var v serveValue
+		dec := json.NewDecoder(io.LimitReader(r.Body, maxServeBody))
+		if err := dec.Decode(&v); err != nil {
+			return http.StatusBadRequest, serveError{
+				fmt.Sprintf("invalid body: %v", err)}
+		}
+		var err error
+		if kind == "ovf" {
+			err = setValueInOvfEnv(key, v.Value, s.config)
+		} else {
+			err = setKeepingRedacted(s.config, guestinfoKey(key), v.Value)
+		}
+		if err != nil {
+			return http.StatusInternalServerError, serveError{err.Error()}
+		}
+		return http.StatusOK, serveValue{Key: key, Value: redact(key, v.Value)}
+	}
+	return http.StatusMethodNotAllowed, serveError{"method not allowed"}
+}
+
+// getOvf returns the readable properties of the OVF environment with the
+// values of sensitive properties redacted.
+func (s *guestinfoServer) getOvf() (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ovfEnv, src, err := getOvfEnvSource(s.config)
+	if err != nil {
+		return http.StatusInternalServerError, serveError{err.Error()}
+	}
+	v := newOvfEnvView(redactOvfEnv(ovfEnv))
+	v.Transport = src.String()
+	props := ovfPropsView{}
+	for _, p := range v.Properties {
+		if s.read.match(ovfKeyPrefix + p.Key) {
+			props = append(props, p)
+		}
+	}
+	v.Properties = props
+	return http.StatusOK, v
+}
@@ -0,0 +1,283 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServeRequest returns a request with the token, if not empty,
+// sent over a unix socket by the user uid, if not negative.
+func newTestServeRequest(
+	method, path, body, token string, uid int) *http.Request {
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if uid >= 0 {
+		r = r.WithContext(context.WithValue(
+			r.Context(), http.LocalAddrContextKey, peerAddr{uid: uid}))
+	}
+	return r
+}
+
+func TestGuestinfoServerAuthorized(t *testing.T) {
+	testCases := []struct {
+		name   string
+		server *guestinfoServer
+		token  string
+		uid    int
+		want   bool
+	}{
+		{
+			name:   "allowed user",
+			server: &guestinfoServer{uids: map[int]bool{0: true}},
+			uid:    0,
+			want:   true,
+		},
+		{
+			name:   "other user",
+			server: &guestinfoServer{uids: map[int]bool{0: true}},
+			uid:    1000,
+		},
+		{
+			name: "any user",
+			server: &guestinfoServer{
+				uids: map[int]bool{0: true}, anyUID: true},
+			uid:  1000,
+			want: true,
+		},
+		{
+			name: "any user over tcp",
+			server: &guestinfoServer{
+				uids: map[int]bool{0: true}, anyUID: true},
+			uid: -1,
+		},
+		{
+			name: "token",
+			server: &guestinfoServer{
+				uids: map[int]bool{0: true}, token: "secret"},
+			token: "secret",
+			uid:   -1,
+			want:  true,
+		},
+		{
+			name: "wrong token",
+			server: &guestinfoServer{
+				uids: map[int]bool{0: true}, token: "secret"},
+			token: "secreT",
+			uid:   -1,
+		},
+		{
+			name: "wrong token from an allowed user",
+			server: &guestinfoServer{
+				uids: map[int]bool{0: true}, token: "secret"},
+			token: "wrong",
+			uid:   0,
+			want:  true,
+		},
+		{
+			name:   "token without a server token",
+			server: &guestinfoServer{uids: map[int]bool{}},
+			token:  "secret",
+			uid:    -1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestServeRequest(
+				http.MethodGet, "/v1/keys/sk8.A", "", tc.token, tc.uid)
+			if got := tc.server.authorized(r); got != tc.want {
+				t.Errorf("authorized = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGuestinfoServerHandle(t *testing.T) {
+	mem := newMemoryBackend()
+	mem.SetString("guestinfo.sk8.A", "1")
+	mem.SetString("guestinfo.sk8.VSPHERE_PASSWORD", "secret")
+	mem.SetString("guestinfo.other", "2")
+	s := &guestinfoServer{
+		config: mem,
+		read:   keyPatterns{"SK8.*", "OVFENV.*"},
+		write:  keyPatterns{"SK8.B", "SK8.VSPHERE_*"},
+		token:  "token",
+		uids:   map[int]bool{},
+	}
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   interface{}
+	}{
+		{
+			name:       "unauthorized",
+			method:     http.MethodGet,
+			path:       "/v1/keys/sk8.A",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "read",
+			method:     http.MethodGet,
+			path:       "/v1/keys/sk8.A",
+			token:      "token",
+			wantStatus: http.StatusOK,
+			wantBody:   serveValue{Key: "sk8.A", Value: "1"},
+		},
+		{
+			name:       "read with the guestinfo prefix",
+			method:     http.MethodGet,
+			path:       "/v1/keys/guestinfo.sk8.A",
+			token:      "token",
+			wantStatus: http.StatusOK,
+			wantBody:   serveValue{Key: "sk8.A", Value: "1"},
+		},
+		{
+			name:       "read sensitive",
+			method:     http.MethodGet,
+			path:       "/v1/keys/sk8.VSPHERE_PASSWORD",
+			token:      "token",
+			wantStatus: http.StatusOK,
+			wantBody: serveValue{
+				Key: "sk8.VSPHERE_PASSWORD", Value: redactedValue},
+		},
+		{
+			name:       "read not allowed",
+			method:     http.MethodGet,
+			path:       "/v1/keys/other",
+			token:      "token",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "write",
+			method:     http.MethodPut,
+			path:       "/v1/keys/sk8.B",
+			body:       `{"value": "3"}`,
+			token:      "token",
+			wantStatus: http.StatusOK,
+			wantBody:   serveValue{Key: "sk8.B", Value: "3"},
+		},
+		{
+			name:       "write not allowed",
+			method:     http.MethodPut,
+			path:       "/v1/keys/sk8.A",
+			body:       `{"value": "3"}`,
+			token:      "token",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "write the redaction placeholder",
+			method:     http.MethodPut,
+			path:       "/v1/keys/sk8.VSPHERE_PASSWORD",
+			body:       `{"value": "` + redactedValue + `"}`,
+			token:      "token",
+			wantStatus: http.StatusOK,
+			wantBody: serveValue{
+				Key: "sk8.VSPHERE_PASSWORD", Value: redactedValue},
+		},
+		{
+			name:       "write the redaction placeholder without a value",
+			method:     http.MethodPut,
+			path:       "/v1/keys/sk8.VSPHERE_USER",
+			body:       `{"value": "` + redactedValue + `"}`,
+			token:      "token",
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "invalid body",
+			method:     http.MethodPut,
+			path:       "/v1/keys/sk8.B",
+			body:       "3",
+			token:      "token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "nested key",
+			method:     http.MethodGet,
+			path:       "/v1/keys/sk8/A",
+			token:      "token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unknown path",
+			method:     http.MethodGet,
+			path:       "/v2/keys/sk8.A",
+			token:      "token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unknown method",
+			method:     http.MethodDelete,
+			path:       "/v1/keys/sk8.A",
+			token:      "token",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := s.handle(newTestServeRequest(
+				tc.method, tc.path, tc.body, tc.token, -1))
+			if status != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %v",
+					status, tc.wantStatus, body)
+			}
+			if tc.wantBody != nil && body != tc.wantBody {
+				t.Errorf("body = %+v, want %+v", body, tc.wantBody)
+			}
+		})
+	}
+
+	// The placeholder did not replace the stored value.
+	if val, _ := mem.String("guestinfo.sk8.VSPHERE_PASSWORD", ""); val != "secret" {
+		t.Errorf("sk8.VSPHERE_PASSWORD = %q, want %q", val, "secret")
+	}
+}
+
+func TestKeyPatterns(t *testing.T) {
+	var p keyPatterns
+	if err := p.Set("sk8.*, ovfEnv.NUM_NODES,"); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		key  string
+		want bool
+	}{
+		{key: "sk8.A", want: true},
+		{key: "SK8.a", want: true},
+		{key: "sk8", want: false},
+		{key: "other.sk8.A", want: false},
+		{key: "ovfenv.num_nodes", want: true},
+		{key: "ovfEnv.NUM_NODES_2", want: false},
+	}
+	for _, tc := range testCases {
+		if got := p.match(tc.key); got != tc.want {
+			t.Errorf("match(%q) = %v, want %v", tc.key, got, tc.want)
+		}
+	}
+	if err := p.Set("[a"); err == nil {
+		t.Error("Set([a) succeeded, want error")
+	}
+}
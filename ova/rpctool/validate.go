@@ -0,0 +1,121 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultDescriptor is the OVF descriptor fragment installed with sk8.
+const defaultDescriptor = "/var/lib/sk8/product-section.ovf"
+
+// validate resolves the properties declared by an OVF descriptor and
+// reports every value that violates its property's type or qualifiers.
+func validate(config Backend, args []string) error {
+	fs := newFlagSet("validate")
+	descriptor := fs.String(
+		"descriptor", defaultDescriptor,
+		"The OVF descriptor, or a fragment with its ProductSection, that "+
+			"declares the properties.")
+	strict := fs.Bool(
+		"strict", false,
+		"Check the MinLen of string properties whose values are unset.")
+	rf := addResolverFlags(fs)
+	df := addDecryptFlags(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	df.apply(config)
+
+	props, err := readOvfDescriptor(*descriptor)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		want := map[string]bool{}
+		for _, k := range keys {
+			want[strings.ToUpper(k)] = true
+		}
+		var filtered []ovfDescProperty
+		for _, p := range props {
+			if want[strings.ToUpper(p.fullKey)] {
+				filtered = append(filtered, p)
+				delete(want, strings.ToUpper(p.fullKey))
+			}
+		}
+		for _, k := range keys {
+			if want[strings.ToUpper(k)] {
+				return fmt.Errorf("%s is not declared by %s", k, *descriptor)
+			}
+		}
+		props = filtered
+	}
+
+	// The descriptor's default values are used when a property is not
+	// otherwise set, but the defaults from the flags take precedence.
+	r, err := rf.newResolver(config)
+	if err != nil {
+		return err
+	}
+	for _, p := range props {
+		if _, ok := r.defaults[p.fullKey]; !ok && p.Default != nil {
+			r.defaults[p.fullKey] = *p.Default
+		}
+	}
+
+	var violations int
+	if err := batch(config, func() error {
+		for _, p := range props {
+			rv, err := r.resolve(p.fullKey)
+			if err != nil {
+				return err
+			}
+			// A value that is still sealed cannot be checked.
+			if isSealed(rv.Value) {
+				continue
+			}
+			// An unset string is optional unless -strict is specified,
+			// so its MinLen is not checked.
+			var errs []string
+			if rv.Source == "" && p.Type != "string" {
+				errs = []string{"value is not set"}
+			} else {
+				errs = p.checkValue(
+					rv.Value, rv.Source == "" && !*strict)
+			}
+			for _, e := range errs {
+				fmt.Fprintf(
+					os.Stderr, "%s: %s (%s): %s\n",
+					p.fullKey, strconv.Quote(redact(p.fullKey, rv.Value)),
+					sourceOrUnset(rv), e)
+			}
+			if len(errs) > 0 {
+				violations++
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if violations > 0 {
+		return fmt.Errorf(
+			"%d of %d values are invalid", violations, len(props))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDescriptor is a ProductSection fragment with a property of each
+// kind of qualifier.
+const testDescriptor = `<ProductSection>
+  <Info>Test</Info>
+  <Property ovf:key="NUM_NODES" ovf:type="uint16" ovf:qualifiers="MinValue(1) MaxValue(8)" ovf:value="2"/>
+  <Property ovf:key="K8S_VERSION" ovf:type="string" ovf:qualifiers="MinLen(1) MaxLen(4)" ovf:value="v1"/>
+  <Property ovf:key="VSPHERE_USER" ovf:type="string" ovf:qualifiers="MinLen(1)" ovf:value=""/>
+  <Property ovf:key="NODE_TYPE" ovf:type="string" ovf:qualifiers="ValueMap{&quot;both&quot;, &quot;worker&quot;}" ovf:value="both"/>
+  <Property ovf:key="DEBUG" ovf:type="boolean" ovf:value=""/>
+</ProductSection>
+`
+
+func TestValidate(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "product-section.ovf")
+	if err := ioutil.WriteFile(
+		path, []byte(testDescriptor), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s ovfSources) { ovfEnvSources = s }(ovfEnvSources)
+	ovfEnvSources = ovfSources{{kind: "guestinfo"}}
+
+	testCases := []struct {
+		name        string
+		vals        map[string]string
+		args        []string
+		wantInvalid int
+		wantErr     bool
+	}{
+		{
+			name: "valid",
+			vals: map[string]string{"DEBUG": "true"},
+		},
+		{
+			name:        "unset boolean",
+			wantInvalid: 1,
+		},
+		{
+			name:        "unset string with -strict",
+			vals:        map[string]string{"DEBUG": "true"},
+			args:        []string{"-strict"},
+			wantInvalid: 1,
+		},
+		{
+			name: "set string with -strict",
+			vals: map[string]string{"DEBUG": "true", "VSPHERE_USER": "u"},
+			args: []string{"-strict"},
+		},
+		{
+			name: "invalid values",
+			vals: map[string]string{
+				"DEBUG":       "yes",
+				"NUM_NODES":   "9",
+				"K8S_VERSION": "v1.12",
+				"NODE_TYPE":   "controller",
+			},
+			wantInvalid: 4,
+		},
+		{
+			name: "MaxLen counts characters",
+			vals: map[string]string{"DEBUG": "true", "K8S_VERSION": "ü✓ü✓"},
+		},
+		{
+			name: "sealed value",
+			vals: map[string]string{
+				"DEBUG": "true", "NUM_NODES": sealedPrefix + "x:y"},
+		},
+		{
+			name: "keys",
+			args: []string{"NUM_NODES", "vsphere_user"},
+		},
+		{
+			name:    "undeclared key",
+			args:    []string{"CLUSTER_NAME"},
+			wantErr: true,
+		},
+		{
+			name: "default from a flag",
+			args: []string{"-default", "DEBUG=false"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := newMemoryBackend()
+			for k, v := range tc.vals {
+				mem.SetString(guestinfoKey("sk8."+k), v)
+			}
+			err := validate(&valueBackend{Backend: mem},
+				append([]string{"-descriptor", path}, tc.args...))
+			switch {
+			case tc.wantErr:
+				if err == nil {
+					t.Fatal("validate succeeded, want error")
+				}
+			case tc.wantInvalid > 0:
+				want := fmt.Sprintf("%d of ", tc.wantInvalid)
+				if err == nil || !strings.HasPrefix(err.Error(), want) {
+					t.Fatalf("error = %v, want %d invalid values",
+						err, tc.wantInvalid)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+		})
+	}
+}
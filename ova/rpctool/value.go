@@ -0,0 +1,335 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// chunkedPrefix is the prefix of the manifest stored in place of a
+	// value that was split across several keys.
+	chunkedPrefix = "rpctool.chunked:"
+
+	// defaultChunkSize is the default maximum size, in bytes, of a chunk.
+	defaultChunkSize = 32 * 1024
+
+	// encodingSuffix is the suffix of the sidecar key that records the
+	// encoding of a value, ex. guestinfo.KEY.encoding. This is the same
+	// convention used by cloud-init's VMware GuestInfo datasource.
+	encodingSuffix = ".encoding"
+)
+
+// chunkManifest describes a value that was split across the keys
+// KEY.chunk.0 through KEY.chunk.N-1.
+type chunkManifest struct {
+	Count  int    `json:"count"`
+	Length int    `json:"length"`
+	SHA256 string `json:"sha256"`
+}
+
+// valueBackend is a Backend that transparently reassembles and verifies
+// chunked values, and decodes encoded values, when they are read.
+type valueBackend struct {
+	Backend
+
+	// chunkSize is the maximum size of a value written with SetString
+	// before the value is chunked. Chunking is disabled if chunkSize is
+	// less than or equal to zero.
+	chunkSize int
+
+	// encoding is the encoding applied to a value written with SetString.
+	// Please see encodeValue for the valid encodings.
+	encoding string
+
+	// keyFile is the private key used to open sealed values when they are
+	// read. Sealed values are returned as-is if keyFile is empty.
+	keyFile string
+
+	// privateKey is read from keyFile when the first sealed value is.
+	privateKey *rsa.PrivateKey
+}
+
+// Batch runs fn in a single session of the underlying backend.
+func (b *valueBackend) Batch(fn func() error) error {
+	return batch(b.Backend, fn)
+}
+
+func (b *valueBackend) String(key, defaultValue string) (string, error) {
+	val, err := b.Backend.String(key, defaultValue)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(val, chunkedPrefix) {
+		if val, err = b.readChunks(key, val); err != nil {
+			return "", err
+		}
+	}
+	if val == "" || strings.HasSuffix(key, encodingSuffix) {
+		return val, nil
+	}
+	enc, err := b.Backend.String(key+encodingSuffix, "")
+	if err != nil {
+		return "", err
+	}
+	if val, err = decodeValue(val, enc); err != nil {
+		return "", fmt.Errorf("invalid %s value: %v", enc, err)
+	}
+	return b.open(val)
+}
+
+// open returns the plaintext of a sealed value if keyFile is set.
+// Values that are not sealed are returned as-is.
+func (b *valueBackend) open(val string) (string, error) {
+	if b.keyFile == "" || !isSealed(val) {
+		return val, nil
+	}
+	if b.privateKey == nil {
+		priv, err := readSealPrivateKey(b.keyFile)
+		if err != nil {
+			return "", err
+		}
+		b.privateKey = priv
+	}
+	return openValue(b.privateKey, val)
+}
+
+func (b *valueBackend) SetString(key, value string) error {
+	if strings.HasSuffix(key, encodingSuffix) {
+		return b.Backend.SetString(key, value)
+	}
+
+	value, err := encodeValue(value, b.encoding)
+	if err != nil {
+		return err
+	}
+
+	// If the encoding changes then the sidecar key is cleared before the
+	// value is written and set after it, so a reader never decodes a
+	// value with another value's encoding.
+	enc, err := b.Backend.String(key+encodingSuffix, "")
+	if err != nil {
+		return err
+	}
+	if enc != "" && enc != b.encoding {
+		if err := b.Backend.SetString(key+encodingSuffix, ""); err != nil {
+			return err
+		}
+	}
+	if err := b.setChunks(key, value); err != nil {
+		return err
+	}
+	if b.encoding != "" && b.encoding != enc {
+		return b.Backend.SetString(key+encodingSuffix, b.encoding)
+	}
+	return nil
+}
+
+// setChunks writes the value, splitting it across several keys if it is
+// larger than chunkSize. The chunks of the previous value that are no
+// longer used are blanked, even if chunking is disabled.
+func (b *valueBackend) setChunks(key, value string) error {
+	// Read the previous manifest, if any, so that stale chunks may be
+	// removed once the new value is written.
+	prev, err := b.Backend.String(key, "")
+	if err != nil {
+		return err
+	}
+	var prevManifest chunkManifest
+	if strings.HasPrefix(prev, chunkedPrefix) {
+		json.Unmarshal([]byte(prev[len(chunkedPrefix):]), &prevManifest)
+	}
+
+	var chunks []string
+	if b.chunkSize > 0 && len(value) > b.chunkSize {
+		chunks = splitChunks(value, b.chunkSize)
+		for i, c := range chunks {
+			if err := b.Backend.SetString(chunkKey(key, i), c); err != nil {
+				return err
+			}
+		}
+		sum := sha256.Sum256([]byte(value))
+		manifest, _ := json.Marshal(chunkManifest{
+			Count:  len(chunks),
+			Length: len(value),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		value = chunkedPrefix + string(manifest)
+	}
+
+	// The manifest is written after the chunks so a reader never sees a
+	// manifest that refers to chunks which do not exist yet.
+	if err := b.Backend.SetString(key, value); err != nil {
+		return err
+	}
+	for i := len(chunks); i < prevManifest.Count; i++ {
+		if err := b.Backend.SetString(chunkKey(key, i), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clear blanks the key, any chunks of the key's value, and the key's
+// encoding sidecar key.
+func (b *valueBackend) clear(key string) error {
+	raw, err := b.Backend.String(key, "")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(raw, chunkedPrefix) {
+		var m chunkManifest
+		json.Unmarshal([]byte(raw[len(chunkedPrefix):]), &m)
+		for i := 0; i < m.Count; i++ {
+			if err := b.Backend.SetString(chunkKey(key, i), ""); err != nil {
+				return err
+			}
+		}
+	}
+	if raw != "" {
+		if err := b.Backend.SetString(key, ""); err != nil {
+			return err
+		}
+	}
+	enc, err := b.Backend.String(key+encodingSuffix, "")
+	if err != nil || enc == "" {
+		return err
+	}
+	return b.Backend.SetString(key+encodingSuffix, "")
+}
+
+// readChunks reassembles the value described by the manifest and
+// verifies the value's length and checksum.
+func (b *valueBackend) readChunks(key, manifest string) (string, error) {
+	var m chunkManifest
+	if err := json.Unmarshal(
+		[]byte(manifest[len(chunkedPrefix):]), &m); err != nil {
+		return "", fmt.Errorf("invalid chunk manifest: %v", err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < m.Count; i++ {
+		c, err := b.Backend.String(chunkKey(key, i), "")
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(c)
+	}
+	if buf.Len() != m.Length {
+		return "", fmt.Errorf(
+			"chunked value is corrupt: expected %d bytes, got %d",
+			m.Length, buf.Len())
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), m.SHA256) {
+		return "", errors.New("chunked value is corrupt: checksum mismatch")
+	}
+	return buf.String(), nil
+}
+
+// chunkKey returns the key that holds the i-th chunk of the value of key.
+func chunkKey(key string, i int) string {
+	return fmt.Sprintf("%s.chunk.%d", guestinfoKey(key), i)
+}
+
+// splitChunks splits s into chunks of at most size bytes. Chunks are
+// split on rune boundaries so that each chunk remains valid UTF-8.
+func splitChunks(s string, size int) []string {
+	var chunks []string
+	for len(s) > size {
+		i := size
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = size
+		}
+		chunks = append(chunks, s[:i])
+		s = s[i:]
+	}
+	return append(chunks, s)
+}
+
+// parseEncoding returns the canonical name of the encoding. The aliases
+// accepted by cloud-init, "b64" and "gz+b64", are also valid.
+func parseEncoding(enc string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(enc)) {
+	case "":
+		return "", nil
+	case "base64", "b64":
+		return "base64", nil
+	case "gzip+base64", "gz+b64":
+		return "gzip+base64", nil
+	}
+	return "", fmt.Errorf("invalid encoding: %s", enc)
+}
+
+// encodeValue encodes the value with the encoding.
+func encodeValue(val, enc string) (string, error) {
+	enc, err := parseEncoding(enc)
+	if err != nil {
+		return "", err
+	}
+	switch enc {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(val)), nil
+	case "gzip+base64":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(val)); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+	return val, nil
+}
+
+// decodeValue decodes the value with the encoding.
+func decodeValue(val, enc string) (string, error) {
+	enc, err := parseEncoding(enc)
+	if err != nil || enc == "" {
+		return val, err
+	}
+	// Tolerate whitespace, such as line breaks, in the encoded value.
+	buf, err := base64.StdEncoding.DecodeString(strings.Join(
+		strings.Fields(val), ""))
+	if err != nil {
+		return "", err
+	}
+	if enc == "gzip+base64" {
+		r, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		if buf, err = ioutil.ReadAll(r); err != nil {
+			return "", err
+		}
+	}
+	return string(buf), nil
+}
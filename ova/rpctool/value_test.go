@@ -0,0 +1,259 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestValueBackendChunks(t *testing.T) {
+	testCases := []struct {
+		name       string
+		chunkSize  int
+		value      string
+		wantChunks int
+	}{
+		{
+			name:      "chunking disabled",
+			chunkSize: 0,
+			value:     strings.Repeat("a", 100),
+		},
+		{
+			name:      "value fits",
+			chunkSize: 10,
+			value:     "0123456789",
+		},
+		{
+			name:       "value split",
+			chunkSize:  10,
+			value:      strings.Repeat("a", 25),
+			wantChunks: 3,
+		},
+		{
+			name:       "multi-byte runes",
+			chunkSize:  4,
+			value:      "ééééé",
+			wantChunks: 3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := newMemoryBackend()
+			b := &valueBackend{Backend: mem, chunkSize: tc.chunkSize}
+			if err := b.SetString("sk8.A", tc.value); err != nil {
+				t.Fatal(err)
+			}
+			val, err := b.String("sk8.A", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val != tc.value {
+				t.Errorf("String() = %q, want %q", val, tc.value)
+			}
+
+			raw, _ := mem.String("sk8.A", "")
+			if chunked := strings.HasPrefix(raw, chunkedPrefix); chunked !=
+				(tc.wantChunks > 0) {
+				t.Errorf("stored value = %q, want chunked %v",
+					raw, tc.wantChunks > 0)
+			}
+			for i := 0; i < tc.wantChunks; i++ {
+				c, _ := mem.String(chunkKey("sk8.A", i), "")
+				if c == "" || !utf8.ValidString(c) {
+					t.Errorf("chunk %d = %q", i, c)
+				}
+			}
+			if c, _ := mem.String(
+				chunkKey("sk8.A", tc.wantChunks), ""); c != "" {
+				t.Errorf("unexpected chunk %d = %q", tc.wantChunks, c)
+			}
+		})
+	}
+}
+
+func TestValueBackendStaleChunks(t *testing.T) {
+	testCases := []struct {
+		name      string
+		chunkSize int
+		value     string
+	}{
+		{name: "fewer chunks", chunkSize: 10, value: strings.Repeat("b", 15)},
+		{name: "not chunked", chunkSize: 10, value: "b"},
+		{name: "chunking disabled", chunkSize: 0, value: strings.Repeat("b", 15)},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := newMemoryBackend()
+			b := &valueBackend{Backend: mem, chunkSize: 10}
+			if err := b.SetString("sk8.A", strings.Repeat("a", 45)); err != nil {
+				t.Fatal(err)
+			}
+			b.chunkSize = tc.chunkSize
+			if err := b.SetString("sk8.A", tc.value); err != nil {
+				t.Fatal(err)
+			}
+			val, err := b.String("sk8.A", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val != tc.value {
+				t.Errorf("String() = %q, want %q", val, tc.value)
+			}
+			used := len(splitChunks(tc.value, 10))
+			if tc.chunkSize == 0 || len(tc.value) <= tc.chunkSize {
+				used = 0
+			}
+			for i := used; i < 5; i++ {
+				if c, _ := mem.String(chunkKey("sk8.A", i), ""); c != "" {
+					t.Errorf("stale chunk %d = %q", i, c)
+				}
+			}
+		})
+	}
+}
+
+func TestValueBackendCorruptChunks(t *testing.T) {
+	testCases := []struct {
+		name  string
+		key   string
+		value string
+	}{
+		{name: "modified chunk", key: "sk8.A.chunk.1", value: "bbbbbbbbbb"},
+		{name: "missing chunk", key: "sk8.A.chunk.2", value: ""},
+		{name: "invalid manifest", key: "sk8.A", value: chunkedPrefix + "{"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := newMemoryBackend()
+			b := &valueBackend{Backend: mem, chunkSize: 10}
+			if err := b.SetString("sk8.A", strings.Repeat("a", 25)); err != nil {
+				t.Fatal(err)
+			}
+			if err := mem.SetString(tc.key, tc.value); err != nil {
+				t.Fatal(err)
+			}
+			if val, err := b.String("sk8.A", ""); err == nil {
+				t.Errorf("String() = %q, want error", val)
+			}
+		})
+	}
+}
+
+func TestValueBackendEncoding(t *testing.T) {
+	const value = "line 1\nline 2\n"
+	testCases := []struct {
+		encoding string
+		wantEnc  string
+		wantErr  bool
+	}{
+		{encoding: "", wantEnc: ""},
+		{encoding: "base64", wantEnc: "base64"},
+		{encoding: "b64", wantEnc: "base64"},
+		{encoding: "gzip+base64", wantEnc: "gzip+base64"},
+		{encoding: "gz+b64", wantEnc: "gzip+base64"},
+		{encoding: "rot13", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.encoding, func(t *testing.T) {
+			mem := newMemoryBackend()
+			enc, err := parseEncoding(tc.encoding)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseEncoding(%q) error = %v, wantErr %v",
+					tc.encoding, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			b := &valueBackend{Backend: mem, encoding: enc}
+			if err := b.SetString("sk8.A", value); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := mem.String("sk8.A"+encodingSuffix, ""); got != tc.wantEnc {
+				t.Errorf("encoding = %q, want %q", got, tc.wantEnc)
+			}
+			if raw, _ := mem.String("sk8.A", ""); (raw == value) !=
+				(tc.wantEnc == "") {
+				t.Errorf("stored value = %q", raw)
+			}
+			val, err := b.String("sk8.A", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if val != value {
+				t.Errorf("String() = %q, want %q", val, value)
+			}
+
+			// Writing the value without an encoding clears the sidecar.
+			b.encoding = ""
+			if err := b.SetString("sk8.A", "plain"); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := mem.String("sk8.A"+encodingSuffix, ""); got != "" {
+				t.Errorf("encoding after plain write = %q, want \"\"", got)
+			}
+			if val, _ := b.String("sk8.A", ""); val != "plain" {
+				t.Errorf("String() after plain write = %q, want %q",
+					val, "plain")
+			}
+		})
+	}
+}
+
+func TestValueBackendClear(t *testing.T) {
+	mem := newMemoryBackend()
+	b := &valueBackend{Backend: mem, chunkSize: 10, encoding: "base64"}
+	if err := b.SetString("sk8.A", strings.Repeat("a", 25)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.clear("sk8.A"); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range mem.keys() {
+		if v, _ := mem.String(k, ""); v != "" {
+			t.Errorf("%s = %q after clear, want \"\"", k, v)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		enc     string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", value: "abc", want: "abc"},
+		{name: "base64", value: "YWJj", enc: "base64", want: "abc"},
+		{name: "line breaks", value: "YW\nJj\n", enc: "b64", want: "abc"},
+		{name: "invalid base64", value: "!!", enc: "base64", wantErr: true},
+		{name: "not gzip", value: "YWJj", enc: "gzip+base64", wantErr: true},
+		{name: "invalid encoding", value: "abc", enc: "rot13", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			val, err := decodeValue(tc.value, tc.enc)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && val != tc.want {
+				t.Errorf("decodeValue(%q, %q) = %q, want %q",
+					tc.value, tc.enc, val, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// waitFor blocks until the value of a key, read from either guestinfo or
+// the OVF environment, satisfies a condition. The value is printed once
+// the condition holds. If the timeout elapses first then an exitCodeError
+// with the status exitTimeout is returned.
+func waitFor(config Backend, args []string) error {
+	fs := newFlagSet("wait")
+	source := fs.String(
+		"source", "guestinfo",
+		"The source of the value: \"guestinfo\" or \"ovf\".")
+	equals := fs.String(
+		"equals", "",
+		"Wait until the value is equal to this value.")
+	fs.Bool(
+		"nonempty", false,
+		"Wait until the value is not empty. This is the default condition.")
+	matches := fs.String(
+		"matches", "",
+		"Wait until the value matches this regular expression.")
+	timeout := fs.Duration(
+		"timeout", 0,
+		"The maximum amount of time to wait. Zero waits indefinitely.")
+	interval := fs.Duration(
+		"interval", time.Second,
+		"The interval at which the value is polled.")
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 {
+		return fmt.Errorf("invalid number of arguments for wait")
+	}
+	if *source, err = parseChoice(
+		"source", *source, "guestinfo", "ovf"); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval: %s", *interval)
+	}
+	if *timeout < 0 {
+		return fmt.Errorf("invalid timeout: %s", *timeout)
+	}
+
+	// The conditions are mutually exclusive.
+	var (
+		cond     = "nonempty"
+		numConds int
+		rx       *regexp.Regexp
+	)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "equals", "nonempty", "matches":
+			cond = f.Name
+			numConds++
+		}
+	})
+	if numConds > 1 {
+		return fmt.Errorf(
+			"only one of -equals, -nonempty, or -matches may be specified")
+	}
+	if cond == "matches" {
+		if rx, err = regexp.Compile(*matches); err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+	}
+	ok := func(val string) bool {
+		switch cond {
+		case "equals":
+			return val == *equals
+		case "matches":
+			return rx.MatchString(val)
+		}
+		return val != ""
+	}
+
+	key := keys[0]
+	get := func() (val string, err error) {
+		err = batch(config, func() error {
+			if *source == "ovf" {
+				ovfEnv, err := getOvfEnvIfSet(config)
+				if err != nil {
+					return err
+				}
+				val, err = getDecodedOvfEnvProperty(ovfEnv, key)
+				return err
+			}
+			if val, err = config.String(guestinfoKey(key), ""); err != nil {
+				return fmt.Errorf("failed to get %s: %v", guestinfoKey(key), err)
+			}
+			return nil
+		})
+		return
+	}
+
+	var deadline time.Time
+	if *timeout > 0 {
+		deadline = time.Now().Add(*timeout)
+	}
+	for {
+		val, err := get()
+		if err != nil {
+			return err
+		}
+		if ok(val) {
+			if val != "" {
+				fmt.Println(val)
+			}
+			return nil
+		}
+		sleep := *interval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return exitCodeError{
+					fmt.Errorf("timed out waiting for %s", key), exitTimeout}
+			}
+			if remaining < sleep {
+				sleep = remaining
+			}
+		}
+		time.Sleep(sleep)
+	}
+}
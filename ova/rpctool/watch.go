@@ -0,0 +1,135 @@
+// Copyright 2016-2018 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// changeEvent describes a change to the value of a watched key.
+type changeEvent struct {
+	Key       string    `json:"key"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// watch polls the keys and prints a JSON line for each change to the
+// value of a key. If a hook is specified it is run once per change.
+func watch(config Backend, args []string) error {
+	fs := newFlagSet("watch")
+	interval := fs.Duration(
+		"interval", 5*time.Second,
+		"The interval at which the keys are polled.")
+	hook := fs.String(
+		"exec", "",
+		"A command run with /bin/sh for each change. The event is written "+
+			"to the command's stdin, and the key and values are set in the "+
+			"environment variables RPCTOOL_KEY, RPCTOOL_OLD, and RPCTOOL_NEW.")
+	initial := fs.Bool(
+		"initial", false,
+		"Report the initial value of each key that is set as a change.")
+	addShowSecretsFlag(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("invalid number of arguments for watch")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("invalid interval: %s", *interval)
+	}
+	keys = uniqueStrings(keys)
+
+	var vals map[string]string
+	poll := func() (map[string]string, error) {
+		next := map[string]string{}
+		err := batch(config, func() error {
+			for _, k := range keys {
+				val, err := config.String(guestinfoKey(k), "")
+				if err != nil {
+					return fmt.Errorf("failed to get %s: %v", guestinfoKey(k), err)
+				}
+				next[k] = val
+			}
+			return nil
+		})
+		return next, err
+	}
+	if !*initial {
+		if vals, err = poll(); err != nil {
+			return err
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	for {
+		next, err := poll()
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if next[k] == vals[k] {
+				continue
+			}
+			ev := changeEvent{
+				Key:       k,
+				Old:       vals[k],
+				New:       next[k],
+				Timestamp: time.Now().UTC(),
+			}
+			if err := enc.Encode(changeEvent{
+				Key:       ev.Key,
+				Old:       redact(ev.Key, ev.Old),
+				New:       redact(ev.Key, ev.New),
+				Timestamp: ev.Timestamp,
+			}); err != nil {
+				return err
+			}
+			if *hook != "" {
+				if err := runWatchHook(*hook, ev); err != nil {
+					fmt.Fprintf(os.Stderr, "hook failed for %s: %v\n", k, err)
+				}
+			}
+		}
+		vals = next
+		time.Sleep(*interval)
+	}
+}
+
+// runWatchHook runs the hook with /bin/sh for the change.
+func runWatchHook(hook string, ev changeEvent) error {
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	cmd.Stdin = bytes.NewReader(append(buf, '\n'))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(
+		os.Environ(),
+		"RPCTOOL_KEY="+ev.Key,
+		"RPCTOOL_OLD="+ev.Old,
+		"RPCTOOL_NEW="+ev.New)
+	return cmd.Run()
+}
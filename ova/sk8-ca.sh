@@ -32,36 +32,27 @@ export TLS_CA_CRT=/etc/ssl/ca.crt
 export TLS_CA_KEY=/etc/ssl/ca.key
 mkdir -p /etc/ssl && chmod 0755 /etc/ssl
 
-generate_ca() {
-  TLS_DEFAULT_BITS="$(rpc_get TLS_DEFAULT_BITS)"
-  TLS_DEFAULT_DAYS="$(rpc_get TLS_DEFAULT_DAYS)"
-  TLS_COUNTRY_NAME="$(rpc_get TLS_COUNTRY_NAME)"
-  TLS_STATE_OR_PROVINCE_NAME="$(rpc_get TLS_STATE_OR_PROVINCE_NAME)"
-  TLS_LOCALITY_NAME="$(rpc_get TLS_LOCALITY_NAME)"
-  TLS_ORG_NAME="$(rpc_get TLS_ORG_NAME)"
-  TLS_OU_NAME="$(rpc_get TLS_OU_NAME)"
-  TLS_EMAIL="$(rpc_get TLS_EMAIL)"
-  TLS_COMMON_NAME="$(rpc_get TLS_COMMON_NAME)"
-
-  [ -z "${TLS_DEFAULT_BITS}" ] || export TLS_DEFAULT_BITS
-  [ -z "${TLS_DEFAULT_DAYS}" ] || export TLS_DEFAULT_DAYS
-  [ -z "${TLS_COUNTRY_NAME}" ] || export TLS_COUNTRY_NAME
-  [ -z "${TLS_STATE_OR_PROVINCE_NAME}" ] || export TLS_STATE_OR_PROVINCE_NAME
-  [ -z "${TLS_LOCALITY_NAME}" ] || export TLS_LOCALITY_NAME
-  [ -z "${TLS_ORG_NAME}" ] || export TLS_ORG_NAME
-  [ -z "${TLS_OU_NAME}" ] || export TLS_OU_NAME
-  [ -z "${TLS_OU_NAME}" ] || export TLS_OU_NAME
-  [ -z "${TLS_EMAIL}" ] || export TLS_EMAIL
-  [ -z "${TLS_COMMON_NAME}" ] || export TLS_COMMON_NAME
+# The sk8 keys that configure new-ca.sh.
+_tls_keys="TLS_DEFAULT_BITS,TLS_DEFAULT_DAYS,TLS_COUNTRY_NAME"
+_tls_keys="${_tls_keys},TLS_STATE_OR_PROVINCE_NAME,TLS_LOCALITY_NAME"
+_tls_keys="${_tls_keys},TLS_ORG_NAME,TLS_OU_NAME,TLS_EMAIL,TLS_COMMON_NAME"
 
+generate_ca() {
   # Generate a new CA for the cluster.
-  ./new-ca.sh
+  rpctool exec -decrypt -keys "${_tls_keys}" -- ./new-ca.sh
 }
 
 if val="$(rpc_get TLS_CA_PEM)" && [ -n "${val}" ]; then
   info "using CA from TLS_CA_PEM..."
-  echo "${val}" | unmangle_pem | openssl x509 1>"${TLS_CA_CRT}"
-  echo "${val}" | unmangle_pem | openssl  rsa 1>"${TLS_CA_KEY}"
+  # The template's pem function restores the PEM's line breaks in case the
+  # value was entered without them, ex. as an OVF property.
+  _ca_pem="$(mktemp)"
+  echo '{{ resolve "TLS_CA_PEM" | pem }}' | \
+    rpctool render -decrypt -out "${_ca_pem}" -mode 0600 || \
+    fatal "failed to read TLS_CA_PEM"
+  openssl x509 <"${_ca_pem}" 1>"${TLS_CA_CRT}"
+  openssl  rsa <"${_ca_pem}" 1>"${TLS_CA_KEY}"
+  rm -f "${_ca_pem}"
 else
   info "generating x509 self-signed certificate authority..."
   generate_ca
@@ -69,7 +60,8 @@ fi
 
 chmod 0644 "${TLS_CA_CRT}"
 chmod 0400 "${TLS_CA_KEY}"
-rpc_set TLS_CA_PEM - <"${TLS_CA_CRT}"
+# The PEM is base64-encoded so its line breaks survive guestinfo.
+rpc_set TLS_CA_PEM - base64 <"${TLS_CA_CRT}"
 openssl x509 -noout -text <"${TLS_CA_CRT}"
 
 exit 0
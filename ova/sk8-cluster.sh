@@ -52,12 +52,22 @@ set_guestinfo() {
   _vm_uuid="${1}"
   _key="${2}"
   _val="${3}"
-  govc vm.change \
-    -vm.uuid "${_vm_uuid}" \
-    -e "guestinfo.sk8.${_key}=${_val}" || \
+  rpctool -vm.uuid "${_vm_uuid}" set "sk8.${_key}" "${_val}" || \
     fatal "failed to set guestinfo.sk8.${_key} on ${_vm_uuid}"
 }
 
+# Sets a guestinfo property on another VM to the value sealed to the VM's
+# public key, so the value cannot be read from the VM's extraConfig. A
+# clone replaces the key it inherited from this VM when it first boots
+# and publishes the new public key to its guestinfo.
+set_guestinfo_sealed() {
+  _vm_uuid="${1}"
+  _key="${2}"
+  _val="$(printf '%s' "${3}" | rpctool -vm.uuid "${_vm_uuid}" seal -)" || \
+    fatal "failed to seal guestinfo.sk8.${_key} to ${_vm_uuid}"
+  set_guestinfo "${_vm_uuid}" "${_key}" "${_val}"
+}
+
 
 power_on_vm() {
   govc vm.power -on -vm.uuid "${1}" || fatal "failed to power on ${1}"
@@ -121,18 +131,28 @@ EOF
   govc object.collect "${_clone_ipath}" -runtime.powerState poweredOff || \
     fatal "failed to wait for ${_clone_ipath} to be powered off"
 
-  # Update the clone's guestinfo with all of the config properties.
+  # Update the clone's guestinfo with all of the config properties. Since
+  # rpc_get opens sealed values, the secrets are sealed to the clone's key.
   set_guestinfo "${_clone_uuid}" NODE_TYPE      "${_clone_node_type}"
   set_guestinfo "${_clone_uuid}" HOST_FQDN      "${_clone_fqdn}"
   set_guestinfo "${_clone_uuid}" ETCD_DISCOVERY "${ETCD_DISCOVERY}"
-  set_guestinfo "${_clone_uuid}" KUBECONFIG     "$(rpc_get KUBECONFIG)"
-  set_guestinfo "${_clone_uuid}" SSH_PRV_KEY    "$(rpc_get SSH_PRV_KEY)"
-  set_guestinfo "${_clone_uuid}" TLS_CA_PEM     "$(rpc_get TLS_CA_PEM)"
+  set_guestinfo_sealed "${_clone_uuid}" KUBECONFIG  "$(rpc_get KUBECONFIG)"
+  set_guestinfo_sealed "${_clone_uuid}" SSH_PRV_KEY "$(rpc_get SSH_PRV_KEY)"
+  set_guestinfo_sealed "${_clone_uuid}" TLS_CA_PEM  "$(rpc_get TLS_CA_PEM)"
 
-  # Iterate over the configuration keys to set on the new VM.
+  # Iterate over the configuration keys to set on the new VM. A value that
+  # is sealed to this VM is sealed to the clone's key instead of copied in
+  # the clear.
   while IFS= read -r _key; do
     if _val="$(rpc_get "${_key}")" && [ -n "${_val}" ]; then
-      set_guestinfo "${_clone_uuid}" "${_key}" "${_val}"
+      case "$(rpctool resolve "${_key}")" in
+      rpctool.sealed:*)
+        set_guestinfo_sealed "${_clone_uuid}" "${_key}" "${_val}"
+        ;;
+      *)
+        set_guestinfo "${_clone_uuid}" "${_key}" "${_val}"
+        ;;
+      esac
     fi
   done <sk8-config-keys.env
 }
@@ -74,10 +74,8 @@ is_false() { ! is_true "${1}"; }; export is_false
 ################################################################################
 
 # Check for debug mode.
-DEBUG="$(rpctool get sk8.DEBUG)" || \
-  { xc="${?}"; echo2 "rpctool: get sk8.DEBUG failed"; exit "${xc}"; }
-[ -n "${DEBUG}" ] || DEBUG="$(rpctool get.ovf DEBUG)" || \
-  { xc="${?}"; echo2 "rpctool: get ovfEnv.DEBUG failed"; exit "${xc}"; }
+DEBUG="$(rpctool resolve DEBUG)" || \
+  { xc="${?}"; echo2 "rpctool: resolve DEBUG failed"; exit "${xc}"; }
 DEBUG="$(parse_bool "${DEBUG}")"
 is_debug() { [ "${DEBUG}" = "true" ]; }
 export DEBUG is_debug
@@ -99,10 +97,8 @@ is_debug && { set -x; LOG_LEVEL="${DEBUG_LEVEL}"; }
 #   * 4, INFO
 #   * 5, DEBUG
 if [ -z "${LOG_LEVEL}" ]; then
-  LOG_LEVEL="$(rpctool get sk8.LOG_LEVEL)" || \
-    { xc="${?}"; echo2 "rpctool: get sk8.LOG_LEVEL failed"; exit "${xc}"; }
-  [ -n "${LOG_LEVEL}" ] || LOG_LEVEL="$(rpctool get.ovf LOG_LEVEL)" || \
-    { xc="${?}"; echo2 "rpctool: get ovfEnv.LOG_LEVEL failed"; exit "${xc}"; }
+  LOG_LEVEL="$(rpctool resolve LOG_LEVEL)" || \
+    { xc="${?}"; echo2 "rpctool: resolve LOG_LEVEL failed"; exit "${xc}"; }
   LOG_LEVEL="${LOG_LEVEL:-${INFO_LEVEL}}"
 fi
 
@@ -187,31 +183,27 @@ export require
 #
 # ex. VMware-42 30 bd 07 d9 68 0c ae-58 61 e9 3c 47 c1 9e d2
 get_self_uuid() {
-  cut -c8- </sys/class/dmi/id/product_serial | \
-  tr -d ' -' | \
-  sed 's/^\([[:alnum:]]\{1,8\}\)\([[:alnum:]]\{1,4\}\)\([[:alnum:]]\{1,4\}\)\([[:alnum:]]\{1,4\}\)\([[:alnum:]]\{1,12\}\)$/\1-\2-\3-\4-\5/' || \
-  fatal "failed to read VM UUID"
+  rpctool platform -uuid || fatal "failed to read VM UUID"
 }
 export get_self_uuid
 
-# Sets a guestinfo property in the sk8 namespace.
+# Sets a guestinfo property in the sk8 namespace. The optional third
+# argument is the encoding of the stored value, ex. base64 to keep the
+# line breaks of a PEM file.
 rpc_set() {
-  rpctool set "sk8.${1}" "${2}" || fatal "rpctool: set sk8.${1} failed"
+  rpctool set ${3:+-encode "${3}"} "sk8.${1}" "${2}" || \
+    fatal "rpctool: set sk8.${1} failed"
 }
 export rpc_set
 
 # Gets a guestinfo property in the sk8 namespace or the OVF environment.
+# In debug mode the layer that supplied the value is printed to stderr.
 rpc_get() {
-  _val="$(rpctool get "sk8.${1}")" || fatal "rpctool: get sk8.${1} failed"
-  if [ -n "${_val}" ] && [ ! "${_val}" = "null" ]; then
-    debug "rpc_get: key=${1} src=guestinfo.sk8"
-    echo "${_val}"
-    return
-  fi
-  _val="$(rpctool get.ovf "${1}")" || fatal "rpctool: get.ovf ${1} failed"
-  if [ -n "${_val}" ] && [ ! "${_val}" = "null" ]; then
-    debug "rpc_get: key=${1} src=guestinfo.ovfEnv"
-    echo "${_val}"
+  if is_debug; then
+    rpctool resolve -decrypt -explain "${1}" || \
+      fatal "rpctool: resolve ${1} failed"
+  else
+    rpctool resolve -decrypt "${1}" || fatal "rpctool: resolve ${1} failed"
   fi
 }
 export rpc_get
@@ -302,12 +294,14 @@ set_host_name() {
 
   # Update the hosts file so the "hostname" command will respond with
   # the correct values for "hostname -f", "hostname -s", and "hostname -d".
-  cat <<EOF >/etc/hosts
+  HOST_FQDN="${_host_fqdn}" HOST_NAME="${_host_name}" \
+    DOMAIN_NAME="${_domain_name}" \
+    rpctool render -out /etc/hosts <<'EOF' || return "${?}"
 ::1         ipv6-localhost ipv6-loopback
 127.0.0.1   localhost
-127.0.0.1   localhost.${_domain_name}
-127.0.0.1   ${_host_name}
-127.0.0.1   ${_host_fqdn}
+127.0.0.1   localhost.{{ env "DOMAIN_NAME" }}
+127.0.0.1   {{ env "HOST_NAME" }}
+127.0.0.1   {{ env "HOST_FQDN" }}
 EOF
 
   _act_host_fqdn="$(hostname -f)" || return "${?}"
@@ -348,13 +342,6 @@ curl -sSL http://bit.ly/sk8-local | sh -s -- ${_vm_uuid}
 EOF
 }
 
-unmangle_pem() {
-  sed -r 's/(-{5}BEGIN [A-Z ]+-{5})/&\n/g; s/(-{5}END [A-Z ]+-{5})/\n&\n/g' | \
-    sed -r 's/.{64}/&\n/g; /^\s*$/d' | \
-    sed -r '/^$/d'
-}
-export unmangle_pem
-
 # Returns the last argument as an array using a POSIX-compliant method
 # for handling arrays.
 only_last_arg() {
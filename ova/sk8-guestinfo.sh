@@ -30,23 +30,15 @@ touch "${_done_file}"
 
 SK8_DEFAULTS="${SK8_DEFAULTS:-/etc/default/sk8}"
 
-write_config_val() {
-  if [ -n "${2}" ]; then
-    printf '%s="%s"\n' "${1}" "${2}" >>"${SK8_DEFAULTS}"
-    printf 'set config val\n  key = %s\n' "${1}" 1>&2
-  elif val="$(rpc_get "${1}")" && [ -n "${val}" ]; then
-    printf '%s="%s"\n' "${1}" "${val}" >>"${SK8_DEFAULTS}"
-    printf 'set config val\n  key = %s\n' "${1}" 1>&2
-  fi
-}
-
-# Write the following config keys to the config file.
-write_config_val NODE_TYPE
-write_config_val ETCD_DISCOVERY
-
-# Iterate over the common config keys to write to the config file.
-while IFS= read -r key; do
-  write_config_val "${key}"
-done <sk8-config-keys.env
+# Write the following config keys, followed by the common config keys, to
+# the config file. The values are quoted by rpctool so the file is safe to
+# source regardless of the characters in the values. Sensitive values are
+# omitted so they are never written to disk. The sk8 service passes the
+# ones sk8 requires to it in its environment.
+umask 0077
+rpctool export -omit-secrets -comments -manifest sk8-config-keys.env \
+  NODE_TYPE ETCD_DISCOVERY >>"${SK8_DEFAULTS}" || \
+  fatal "rpctool: export failed"
+chmod 0600 "${SK8_DEFAULTS}" || fatal "failed to chmod ${SK8_DEFAULTS}"
 
 exit 0
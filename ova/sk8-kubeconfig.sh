@@ -43,24 +43,11 @@ if [ -z "${api_fqdn}" ]; then
   exit 0
 fi
 
-TLS_DEFAULT_BITS="$(rpc_get TLS_DEFAULT_BITS)"
-TLS_DEFAULT_DAYS="$(rpc_get TLS_DEFAULT_DAYS)"
-TLS_COUNTRY_NAME="$(rpc_get TLS_COUNTRY_NAME)"
-TLS_STATE_OR_PROVINCE_NAME="$(rpc_get TLS_STATE_OR_PROVINCE_NAME)"
-TLS_LOCALITY_NAME="$(rpc_get TLS_LOCALITY_NAME)"
-TLS_ORG_NAME="$(rpc_get TLS_ORG_NAME)"
-TLS_OU_NAME="$(rpc_get TLS_OU_NAME)"
-TLS_EMAIL="$(rpc_get TLS_EMAIL)"
-
-[ -z "${TLS_DEFAULT_BITS}" ] || export TLS_DEFAULT_BITS
-[ -z "${TLS_DEFAULT_DAYS}" ] || export TLS_DEFAULT_DAYS
-[ -z "${TLS_COUNTRY_NAME}" ] || export TLS_COUNTRY_NAME
-[ -z "${TLS_STATE_OR_PROVINCE_NAME}" ] || export TLS_STATE_OR_PROVINCE_NAME
-[ -z "${TLS_LOCALITY_NAME}" ] || export TLS_LOCALITY_NAME
-[ -z "${TLS_ORG_NAME}" ] || export TLS_ORG_NAME
-[ -z "${TLS_OU_NAME}" ] || export TLS_OU_NAME
-[ -z "${TLS_OU_NAME}" ] || export TLS_OU_NAME
-[ -z "${TLS_EMAIL}" ] || export TLS_EMAIL
+# The sk8 keys that configure new-cert.sh. TLS_ORG_NAME is omitted since
+# the admin user's organization is always system:masters.
+_tls_keys="TLS_DEFAULT_BITS,TLS_DEFAULT_DAYS,TLS_COUNTRY_NAME"
+_tls_keys="${_tls_keys},TLS_STATE_OR_PROVINCE_NAME,TLS_LOCALITY_NAME"
+_tls_keys="${_tls_keys},TLS_OU_NAME,TLS_EMAIL"
 
 export TLS_CA_CRT=/etc/ssl/ca.crt
 export TLS_CA_KEY=/etc/ssl/ca.key
@@ -75,7 +62,7 @@ TLS_COMMON_NAME="admin" \
   TLS_CRT_OUT="${TLS_CRT}" \
   TLS_KEY_OUT="${TLS_KEY}" \
   TLS_PLAIN_TEXT=true \
-  ./new-cert.sh
+  rpctool exec -decrypt -keys "${_tls_keys}" -- ./new-cert.sh
 
 # Generate a new kubeconfig for the K8s admin user.
 info "generating kubeconfig for the k8s-admin user..."
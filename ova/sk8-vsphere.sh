@@ -101,18 +101,21 @@ export GOVC_NETWORK
 # Remove the JSON file.
 rm -f "${self_json}"
 
-# Write all of the GOVC_ environment variables to GOVC_ENV.
-{ echo "GOVC_SELF=\"${GOVC_SELF}\""; \
-  echo "GOVC_URL=\"${GOVC_URL}\""; \
-  echo "GOVC_INSECURE=\"${GOVC_INSECURE}\""; \
-  echo "GOVC_USERNAME=\"${GOVC_USERNAME}\""; \
-  echo "GOVC_PASSWORD=\"${GOVC_PASSWORD}\""; \
-  echo "GOVC_DATACENTER=\"${GOVC_DATACENTER}\""; \
-  echo "GOVC_DATASTORE=\"${GOVC_DATASTORE}\""; \
-  echo "GOVC_RESOURCE_POOL=\"${GOVC_RESOURCE_POOL}\""; \
-  echo "GOVC_FOLDER=\"${GOVC_FOLDER}\""; \
-  echo "GOVC_NETWORK=\"${GOVC_NETWORK}\""; \
-} >"${govc_env}"
+# Write all of the GOVC_ environment variables to GOVC_ENV. The file
+# holds the vSphere password, so it is readable only by root.
+GOVC_SELF="${GOVC_SELF}" rpctool render -out "${govc_env}" -mode 0600 <<'EOF' || \
+  fatal "failed to write ${govc_env}"
+GOVC_SELF={{ env "GOVC_SELF" | shq }}
+GOVC_URL={{ env "GOVC_URL" | shq }}
+GOVC_INSECURE={{ env "GOVC_INSECURE" | shq }}
+GOVC_USERNAME={{ env "GOVC_USERNAME" | shq }}
+GOVC_PASSWORD={{ env "GOVC_PASSWORD" | shq }}
+GOVC_DATACENTER={{ env "GOVC_DATACENTER" | shq }}
+GOVC_DATASTORE={{ env "GOVC_DATASTORE" | shq }}
+GOVC_RESOURCE_POOL={{ env "GOVC_RESOURCE_POOL" | shq }}
+GOVC_FOLDER={{ env "GOVC_FOLDER" | shq }}
+GOVC_NETWORK={{ env "GOVC_NETWORK" | shq }}
+EOF
 
 # Determine if the vSphere cloud provider should be deployed.
 cloud_provider_type="$(rpc_get CLOUD_PROVIDER_TYPE)"